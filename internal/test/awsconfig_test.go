@@ -0,0 +1,190 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/server"
+)
+
+// This file contains tests for the AWS Parameter Store and Secrets Manager configuration
+// sources using a local stand-in for the AWS services
+
+type standInParam struct {
+	value   string
+	version int64
+}
+
+type awsConfigStandIn struct {
+	params        map[string]standInParam
+	secret        string
+	secretVersion string
+	sync.Mutex
+}
+
+func (s *awsConfigStandIn) setParam(name string, value string) {
+	s.Lock()
+	defer s.Unlock()
+	param := s.params[name]
+	param.value = value
+	param.version++
+	s.params[name] = param
+}
+
+func (s *awsConfigStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	request := struct {
+		Path     string
+		SecretId string
+	}{}
+	if errGo := json.NewDecoder(r.Body).Decode(&request); errGo != nil {
+		http.Error(w, errGo.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{}
+	switch r.Header.Get("X-Amz-Target") {
+	case "AmazonSSM.GetParametersByPath":
+		params := []map[string]interface{}{}
+		for name, param := range s.params {
+			if strings.HasPrefix(name, request.Path) {
+				params = append(params, map[string]interface{}{
+					"Name":    name,
+					"Value":   param.value,
+					"Version": param.version,
+					"Type":    "String",
+				})
+			}
+		}
+		response["Parameters"] = params
+	case "secretsmanager.GetSecretValue":
+		response["Name"] = request.SecretId
+		response["SecretString"] = s.secret
+		response["VersionId"] = s.secretVersion
+	default:
+		http.Error(w, "unsupported action", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func waitConfigUpdate(ctx context.Context, t *testing.T, updateC chan server.K8sConfigUpdate, errorC chan kv.Error) (update server.K8sConfigUpdate) {
+	select {
+	case update = <-updateC:
+	case err := <-errorC:
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal(kv.NewError("configuration update not received").With("stack", stack.Trace().TrimRuntime()))
+	}
+	return update
+}
+
+// TestAWSConfigSources checks that parameter and secret changes are published as config updates
+//
+func TestAWSConfigSources(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	standIn := &awsConfigStandIn{
+		params:        map[string]standInParam{},
+		secret:        `{"DB_PASSWORD": "first", "DB_PORT": 5432}`,
+		secretVersion: "version-1",
+	}
+	standIn.setParam("/runner/prod/LOG_LEVEL", "info")
+	standIn.setParam("/runner/prod/queue/name", "work")
+	standIn.setParam("/runner/test/LOG_LEVEL", "debug")
+
+	service := httptest.NewServer(standIn)
+	defer service.Close()
+
+	cred := &aws_gsc.AWSCred{
+		Project: "test",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("test_access_key", "test_secret_key", ""),
+	}
+	opts := server.AWSConfigOpts{
+		Interval: 20 * time.Millisecond,
+		Endpoint: service.URL,
+	}
+
+	updateC := make(chan server.K8sConfigUpdate, 1)
+	errorC := make(chan kv.Error, 1)
+
+	go server.WatchParameters(ctx, cred, "/runner/prod", opts, updateC, errorC)
+
+	update := waitConfigUpdate(ctx, t, updateC, errorC)
+	if update.NameSpace != server.ParamStoreNameSpace || len(update.State) != 2 ||
+		update.State["LOG_LEVEL"] != "info" || update.State["queue/name"] != "work" {
+		t.Fatal(kv.NewError("unexpected parameters").With("update", update).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// No further updates should arrive until a parameter changes
+	select {
+	case update = <-updateC:
+		t.Fatal(kv.NewError("unchanged parameters were republished").With("stack", stack.Trace().TrimRuntime()))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	standIn.setParam("/runner/prod/LOG_LEVEL", "debug")
+	update = waitConfigUpdate(ctx, t, updateC, errorC)
+	if update.State["LOG_LEVEL"] != "debug" {
+		t.Fatal(kv.NewError("parameter change not published").With("update", update).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	secretC := make(chan server.K8sConfigUpdate, 1)
+	go server.WatchSecret(ctx, cred, "runner/db", opts, secretC, errorC)
+
+	update = waitConfigUpdate(ctx, t, secretC, errorC)
+	if update.NameSpace != server.SecretsNameSpace || update.State["DB_PASSWORD"] != "first" || update.State["DB_PORT"] != "5432" {
+		t.Fatal(kv.NewError("unexpected secret").With("update", update).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	standIn.Lock()
+	standIn.secret = `{"DB_PASSWORD": "second", "DB_PORT": 5432}`
+	standIn.secretVersion = "version-2"
+	standIn.Unlock()
+
+	update = waitConfigUpdate(ctx, t, secretC, errorC)
+	if update.State["DB_PASSWORD"] != "second" {
+		t.Fatal(kv.NewError("secret rotation not published").With("update", update).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Configuration sources sharing a ready channel must close it only once, the sources
+	// return immediately as their ctx is already done
+	doneCtx, doneCancel := context.WithCancel(ctx)
+	doneCancel()
+	readyC := make(chan struct{})
+	for i := 0; i != 2; i++ {
+		server.InitiateAWSConfig(doneCtx, cred, "/runner/test", "", opts, readyC, nil, errorC)
+	}
+	select {
+	case <-readyC:
+	default:
+		t.Fatal(kv.NewError("ready channel not closed").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Kubernetes configuration shares the broadcaster created for AWS configuration
+	listeners := server.K8sConfigUpdates()
+	server.InitiateK8s(doneCtx, "default", "runner-config", readyC, time.Minute, logger, errorC)
+	if listeners == nil || server.K8sConfigUpdates() != listeners {
+		t.Fatal(kv.NewError("configuration broadcaster replaced").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
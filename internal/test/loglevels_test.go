@@ -0,0 +1,100 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+	"github.com/leaf-ai/go-service/pkg/log"
+	"github.com/leaf-ai/go-service/pkg/server"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// TestLogLevels checks that log levels are applied from configuration updates
+//
+func TestLogLevels(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5*time.Second))
+	defer cancel()
+
+	errorC := make(chan kv.Error, 1)
+	broadcast := server.NewConfigBroadcast(ctx, errorC)
+
+	if err := server.WatchLogLevels(ctx, broadcast, errorC); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	queue := log.NewLogger("level-queue").Register()
+	defer queue.Close()
+	queue.SetLevel(logxi.LevelError)
+
+	broadcast.Master <- server.K8sConfigUpdate{
+		Name: "levels",
+		State: map[string]string{
+			"LOG_LEVEL_LEVEL_QUEUE":  "debug",
+			"LOG_LEVEL_LEVEL_RUNNER": "trace",
+		},
+	}
+
+	waitLogLevel(ctx, t, queue, logxi.LevelDebug, errorC)
+
+	// Components without loggers receive their level when their loggers are created, names
+	// are matched in the same way as for existing components
+	runner := log.NewLogger("level-runner").Register()
+	defer runner.Close()
+	if !runner.IsTrace() {
+		t.Fatal("level not applied to new component", runner.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Updates that leave a key unchanged do not revert levels changed by other means, such
+	// as signals.  A new key is used to detect that the update has been applied.
+	marker := log.NewLogger("level-marker").Register()
+	defer marker.Close()
+	marker.SetLevel(logxi.LevelError)
+	log.SetComponentLevel("level-queue", logxi.LevelTrace)
+
+	broadcast.Master <- server.K8sConfigUpdate{
+		Name: "levels",
+		State: map[string]string{
+			"LOG_LEVEL_LEVEL_QUEUE":  "debug",
+			"LOG_LEVEL_LEVEL_RUNNER": "trace",
+			"LOG_LEVEL_LEVEL_MARKER": "warn",
+		},
+	}
+	waitLogLevel(ctx, t, marker, logxi.LevelWarn, errorC)
+	if queue.GetLevel() != logxi.LevelTrace {
+		t.Fatal("unchanged key reapplied", queue.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Keys whose value changes are applied
+	broadcast.Master <- server.K8sConfigUpdate{
+		Name: "levels",
+		State: map[string]string{
+			"LOG_LEVEL_LEVEL_QUEUE":  "info",
+			"LOG_LEVEL_LEVEL_RUNNER": "trace",
+			"LOG_LEVEL_LEVEL_MARKER": "warn",
+		},
+	}
+	waitLogLevel(ctx, t, queue, logxi.LevelInfo, errorC)
+
+	if err := server.ApplyLogLevels(server.K8sConfigUpdate{State: map[string]string{"LOG_LEVEL_LEVEL_QUEUE": "loud"}}); err == nil {
+		t.Fatal("invalid level accepted", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// waitLogLevel waits for a logger to reach a level, failing on any error reported
+func waitLogLevel(ctx context.Context, t *testing.T, logger *log.Logger, level int, errorC chan kv.Error) {
+	for logger.GetLevel() != level {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case err := <-errorC:
+			t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+		case <-ctx.Done():
+			t.Fatal("level not applied", logger.GetLevel(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
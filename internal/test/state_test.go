@@ -11,6 +11,7 @@ import (
 
 	"github.com/go-stack/stack"
 	"github.com/jjeffery/kv" // MIT License
+	"github.com/leaf-ai/go-service/pkg/components"
 	"github.com/leaf-ai/go-service/pkg/server"
 )
 
@@ -94,3 +95,28 @@ func TestBroadcast(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestBroadcastHeartbeat checks that the broadcaster drives a heartbeat while it is idle
+//
+func TestBroadcastHeartbeat(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5*time.Second))
+	defer cancel()
+
+	comps := components.InitComponentTracking(ctx)
+	hb := comps.AddHeartbeat("broadcast", 3*time.Second)
+
+	l := server.NewConfigBroadcast(ctx, make(chan kv.Error, 1))
+	l.SetBeat(hb.Beat)
+
+	for {
+		if comps.Snapshot().Modules["broadcast"].Status == components.Up {
+			return
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal(kv.NewError("the broadcaster did not beat").With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2018-2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package aws_gsc
+
+// This file contains the implementation of support for obtaining temporary credentials by
+// assuming an AWS IAM role, either directly from a set of base credentials or by following
+// a chain of role profiles linked by their source_profile settings in the AWS config files.
+//
+// Temporary credentials are cached by the AWS credentials type until they approach their
+// expiry, the WatchCreds function can be used to refresh them in the background so that
+// callers never block on the STS service.
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/rs/xid"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// AssumeRoleOpts contains the parameters used when assuming a role
+//
+type AssumeRoleOpts struct {
+	RoleARN     string
+	ExternalID  string
+	SessionName string        // Defaults to a unique name, shared by callers that also omit it
+	Duration    time.Duration // Defaults to the STS default of 15 minutes
+	// ExpiryWindow causes the temporary credentials to be treated as expired this long
+	// before their actual expiry
+	ExpiryWindow time.Duration
+	// Endpoint can be used to override the STS service endpoint, for example when using a
+	// local STS stand-in
+	Endpoint string
+}
+
+// roleCacheSize is the number of assumed roles retained, the least recently used are
+// discarded beyond this
+const roleCacheSize = 64
+
+// roleCacheKey identifies the temporary credentials for a role.  Source credentials loaded
+// from a shared credentials file are identified by the file and profile so that each load of
+// the file shares the same roles, other sources by their identity.  Callers that do not
+// supply a session name share a single generated name.
+type roleCacheKey struct {
+	source        *credentials.Credentials
+	sourceFile    string
+	sourceProfile string
+	roleARN       string
+	externalID    string
+	sessionName   string
+	duration      time.Duration
+	expiryWindow  time.Duration
+	endpoint      string
+}
+
+type roleCacheEntry struct {
+	creds    *credentials.Credentials
+	source   *credentials.Credentials // The source used to assume the role
+	lastUsed time.Time
+}
+
+var (
+	// roleCache allows multiple users of the same role and source credentials to share
+	// one set of temporary credentials
+	roleCache = map[roleCacheKey]*roleCacheEntry{}
+	roleGuard sync.Mutex
+)
+
+// AssumeRole will use the receivers credentials as the source for assuming the role described
+// by the options and return a new set of credentials for the assumed role.  The temporary
+// credentials are not retrieved from STS until they are first used.
+//
+func (cred *AWSCred) AssumeRole(opts AssumeRoleOpts) (assumed *AWSCred, err kv.Error) {
+
+	if len(opts.RoleARN) == 0 {
+		return nil, kv.NewError("role ARN not specified").With("project", cred.Project).With("stack", stack.Trace().TrimRuntime())
+	}
+	if cred.Creds == nil {
+		return nil, kv.NewError("source credentials missing").With("project", cred.Project, "role", opts.RoleARN).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	key := roleCacheKey{
+		roleARN:      opts.RoleARN,
+		externalID:   opts.ExternalID,
+		sessionName:  opts.SessionName,
+		duration:     opts.Duration,
+		expiryWindow: opts.ExpiryWindow,
+		endpoint:     opts.Endpoint,
+	}
+	if len(cred.sharedFile) != 0 {
+		key.sourceFile = cred.sharedFile
+		key.sourceProfile = cred.profile
+	} else {
+		key.source = cred.Creds
+	}
+
+	roleGuard.Lock()
+	defer roleGuard.Unlock()
+
+	entry, isPresent := roleCache[key]
+	if !isPresent {
+		if len(opts.SessionName) == 0 {
+			opts.SessionName = "gsc-" + xid.New().String()
+		}
+		cfg := &aws.Config{
+			Region:      aws.String(cred.Region),
+			Credentials: cred.Creds,
+		}
+		if len(opts.Endpoint) != 0 {
+			cfg.Endpoint = aws.String(opts.Endpoint)
+		}
+		sess, errGo := session.NewSession(cfg)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("project", cred.Project, "role", opts.RoleARN).With("stack", stack.Trace().TrimRuntime())
+		}
+
+		creds := stscreds.NewCredentialsWithClient(sts.New(sess), opts.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = opts.SessionName
+			if len(opts.ExternalID) != 0 {
+				p.ExternalID = aws.String(opts.ExternalID)
+			}
+			if opts.Duration > 0 {
+				p.Duration = opts.Duration
+			}
+			p.ExpiryWindow = opts.ExpiryWindow
+		})
+		evictRoles(roleCacheSize - 1)
+		entry = &roleCacheEntry{creds: creds, source: cred.Creds}
+		roleCache[key] = entry
+	}
+	entry.lastUsed = time.Now()
+
+	return &AWSCred{
+		Project: cred.Project,
+		Region:  cred.Region,
+		Creds:   entry.creds,
+		sources: append(append([]*credentials.Credentials{}, cred.sources...), entry.source),
+	}, nil
+}
+
+// evictRoles discards the least recently used roles until no more than limit remain, the
+// caller is expected to be holding the roleGuard lock.  Users of discarded credentials can
+// continue to use them, they are simply no longer shared with new users.
+func evictRoles(limit int) {
+	for len(roleCache) > limit {
+		var oldest roleCacheKey
+		first := true
+		for key, entry := range roleCache {
+			if first || entry.lastUsed.Before(roleCache[oldest].lastUsed) {
+				oldest = key
+				first = false
+			}
+		}
+		delete(roleCache, oldest)
+	}
+}
+
+// readProfiles will load the sections from a set of AWS config and credentials files, the
+// 'profile ' prefix used by the config files is removed so that both files index the same
+// profile names.  Where the same key appears in multiple files the first file wins.
+func readProfiles(filenames []string) (profiles map[string]map[string]string, owners map[string]string) {
+	profiles = map[string]map[string]string{}
+	// owners records the file that the access keys for a profile were found in
+	owners = map[string]string{}
+
+	for _, aFile := range filenames {
+		func() {
+			f, errGo := os.Open(filepath.Clean(aFile))
+			if errGo != nil {
+				return
+			}
+			defer f.Close()
+
+			section := ""
+			scan := bufio.NewScanner(f)
+			for scan.Scan() {
+				line := strings.TrimSpace(scan.Text())
+				if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+					continue
+				}
+				if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+					section = strings.TrimSpace(strings.TrimPrefix(strings.Trim(line, "[]"), "profile "))
+					if _, isPresent := profiles[section]; !isPresent {
+						profiles[section] = map[string]string{}
+					}
+					continue
+				}
+				tokens := strings.SplitN(line, "=", 2)
+				if len(section) == 0 || len(tokens) != 2 {
+					continue
+				}
+				k := strings.ToLower(strings.TrimSpace(tokens[0]))
+				if _, isPresent := profiles[section][k]; isPresent {
+					continue
+				}
+				profiles[section][k] = strings.TrimSpace(tokens[1])
+				if k == "aws_access_key_id" {
+					owners[section] = aFile
+				}
+			}
+		}()
+	}
+	return profiles, owners
+}
+
+// AWSExtractRoleCreds can be used to obtain credentials for a profile that uses role_arn and
+// source_profile settings within the AWS config files.  Chains of profiles are followed until
+// a profile with static access keys is found, the role for each profile in the chain is then
+// assumed in turn starting from the static keys.  Profiles that require MFA, or that use a
+// credential_source are not supported.
+//
+// The options are used as defaults for values not present within the profiles, the RoleARN
+// within the options is ignored.
+//
+func AWSExtractRoleCreds(filenames []string, profile string, opts AssumeRoleOpts) (cred *AWSCred, err kv.Error) {
+
+	profiles, owners := readProfiles(filenames)
+
+	// Walk the chain of source profiles from the requested profile back to the base credentials
+	chain := []string{}
+	visited := map[string]bool{}
+	current := profile
+	region := ""
+	for {
+		values, isPresent := profiles[current]
+		if !isPresent {
+			return nil, kv.NewError("profile not found").With("profile", current, "files", filenames).With("stack", stack.Trace().TrimRuntime())
+		}
+		if visited[current] {
+			return nil, kv.NewError("source_profile loop detected").With("profile", current, "chain", chain).With("stack", stack.Trace().TrimRuntime())
+		}
+		visited[current] = true
+
+		if len(region) == 0 {
+			region = values["region"]
+		}
+
+		if len(values["role_arn"]) == 0 {
+			if _, isPresent := owners[current]; !isPresent {
+				return nil, kv.NewError("credentials not found").With("profile", current, "files", filenames).With("stack", stack.Trace().TrimRuntime())
+			}
+			break
+		}
+		if len(values["mfa_serial"]) != 0 {
+			return nil, kv.NewError("MFA protected roles are not supported").With("profile", current).With("stack", stack.Trace().TrimRuntime())
+		}
+		if len(values["source_profile"]) == 0 {
+			return nil, kv.NewError("role profile has no source_profile").With("profile", current).With("stack", stack.Trace().TrimRuntime())
+		}
+		chain = append(chain, current)
+		current = values["source_profile"]
+	}
+
+	if len(region) == 0 {
+		return nil, kv.NewError("none of the supplied files defined a region").With("profile", profile, "files", filenames).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	cred = &AWSCred{
+		Project:    "aws_" + filepath.Base(filepath.Dir(filenames[0])),
+		Region:     region,
+		Creds:      credentials.NewSharedCredentials(owners[current], current),
+		files:      append([]string{}, filenames...),
+		profile:    current,
+		sharedFile: owners[current],
+	}
+
+	// Assume each of the roles starting from the one closest to the base credentials
+	for i := len(chain) - 1; i >= 0; i-- {
+		values := profiles[chain[i]]
+
+		hop := opts
+		hop.RoleARN = values["role_arn"]
+		if externalID := values["external_id"]; len(externalID) != 0 {
+			hop.ExternalID = externalID
+		}
+		if sessionName := values["role_session_name"]; len(sessionName) != 0 {
+			hop.SessionName = sessionName
+		}
+		if secs := values["duration_seconds"]; len(secs) != 0 {
+			duration, errGo := strconv.Atoi(secs)
+			if errGo != nil {
+				return nil, kv.Wrap(errGo).With("profile", chain[i], "duration_seconds", secs).With("stack", stack.Trace().TrimRuntime())
+			}
+			hop.Duration = time.Duration(duration) * time.Second
+		}
+
+		assumed, err := cred.AssumeRole(hop)
+		if err != nil {
+			return nil, err.With("profile", chain[i])
+		}
+		// Retain the files so that changes to the base credentials can still be watched
+		assumed.files = cred.files
+		assumed.profile = cred.profile
+		cred = assumed
+	}
+
+	return cred, nil
+}
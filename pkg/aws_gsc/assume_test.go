@@ -0,0 +1,281 @@
+// Copyright 2018-2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package aws_gsc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+)
+
+// stsCall records the details of a request made to the STS stand-in
+type stsCall struct {
+	signer      string // The access key ID used to sign the request
+	roleARN     string
+	sessionName string
+	externalID  string
+	duration    string
+}
+
+// stsStandIn is a minimal local implementation of the STS AssumeRole query API
+type stsStandIn struct {
+	calls []stsCall
+	sync.Mutex
+}
+
+func (s *stsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if errGo := r.ParseForm(); errGo != nil {
+		http.Error(w, errGo.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("Action") != "AssumeRole" {
+		http.Error(w, "unsupported action", http.StatusBadRequest)
+		return
+	}
+
+	call := stsCall{
+		roleARN:     r.Form.Get("RoleArn"),
+		sessionName: r.Form.Get("RoleSessionName"),
+		externalID:  r.Form.Get("ExternalId"),
+		duration:    r.Form.Get("DurationSeconds"),
+	}
+	if parts := strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2); len(parts) == 2 {
+		call.signer = strings.SplitN(parts[1], "/", 2)[0]
+	}
+
+	s.Lock()
+	s.calls = append(s.calls, call)
+	s.Unlock()
+
+	role := call.roleARN[strings.LastIndex(call.roleARN, "/")+1:]
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>AKID-%s</AccessKeyId>
+      <SecretAccessKey>secret-%s</SecretAccessKey>
+      <SessionToken>token-%s</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>%s/%s</Arn>
+      <AssumedRoleId>ARO:%s</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>%s</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, role, role, role, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), call.roleARN, call.sessionName, call.sessionName, role)
+}
+
+// TestAssumeRoleChain checks that a chain of role profiles is followed from the base
+// credentials and that each hop is signed using the credentials of the previous hop
+//
+func TestAssumeRoleChain(t *testing.T) {
+
+	standIn := &stsStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	tmpDir, errGo := ioutil.TempDir("", "TestAssumeRoleChain")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFN := filepath.Join(tmpDir, "config")
+	credsFN := filepath.Join(tmpDir, "credentials")
+
+	config := `[default]
+region=us-west-2
+
+[profile first]
+role_arn=arn:aws:iam::111111111111:role/first
+source_profile=default
+external_id=first-external
+
+[profile second]
+role_arn=arn:aws:iam::222222222222:role/second
+source_profile=first
+role_session_name=chained
+duration_seconds=1800
+`
+	creds := `[default]
+aws_access_key_id=AKID-base
+aws_secret_access_key=secret-base
+`
+	if errGo := ioutil.WriteFile(configFN, []byte(config), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "fn", configFN, "stack", stack.Trace().TrimRuntime())
+	}
+	if errGo := ioutil.WriteFile(credsFN, []byte(creds), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "fn", credsFN, "stack", stack.Trace().TrimRuntime())
+	}
+
+	cred, err := AWSExtractRoleCreds([]string{configFN, credsFN}, "second", AssumeRoleOpts{Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	values, errGo := cred.Creds.Get()
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if values.AccessKeyID != "AKID-second" || values.SessionToken != "token-second" {
+		t.Fatal("unexpected credentials", values.AccessKeyID, "stack", stack.Trace().TrimRuntime())
+	}
+
+	standIn.Lock()
+	calls := append([]stsCall{}, standIn.calls...)
+	standIn.Unlock()
+
+	if len(calls) != 2 {
+		t.Fatal("unexpected number of STS calls", len(calls), "stack", stack.Trace().TrimRuntime())
+	}
+	if calls[0].signer != "AKID-base" || calls[0].externalID != "first-external" || !strings.HasPrefix(calls[0].sessionName, "gsc-") {
+		t.Fatal("unexpected first hop", fmt.Sprintf("%+v", calls[0]), "stack", stack.Trace().TrimRuntime())
+	}
+	if calls[1].signer != "AKID-first" || calls[1].sessionName != "chained" || calls[1].duration != "1800" {
+		t.Fatal("unexpected second hop", fmt.Sprintf("%+v", calls[1]), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// The temporary credentials are cached and should not cause further STS traffic, including
+	// when the profiles are loaded again
+	if _, errGo = cred.Creds.Get(); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	again, err := AWSExtractRoleCreds([]string{configFN, credsFN}, "second", AssumeRoleOpts{Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if again.Creds != cred.Creds {
+		t.Fatal("role chain not shared", "stack", stack.Trace().TrimRuntime())
+	}
+	if _, errGo = again.Creds.Get(); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	standIn.Lock()
+	defer standIn.Unlock()
+	if len(standIn.calls) != 2 {
+		t.Fatal("cached credentials were not used", len(standIn.calls), "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestAssumeRoleLoop checks that a loop in the source profiles is detected
+//
+func TestAssumeRoleLoop(t *testing.T) {
+
+	tmpDir, errGo := ioutil.TempDir("", "TestAssumeRoleLoop")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFN := filepath.Join(tmpDir, "config")
+	config := `[profile a]
+region=us-west-2
+role_arn=arn:aws:iam::111111111111:role/a
+source_profile=b
+
+[profile b]
+role_arn=arn:aws:iam::111111111111:role/b
+source_profile=a
+`
+	if errGo := ioutil.WriteFile(configFN, []byte(config), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "fn", configFN, "stack", stack.Trace().TrimRuntime())
+	}
+
+	if _, err := AWSExtractRoleCreds([]string{configFN}, "a", AssumeRoleOpts{}); err == nil {
+		t.Fatal("source profile loop was not detected", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestAssumeRoleCache checks that callers using generated session names share temporary
+// credentials, that callers supplying their own session name or expiry window do not, that
+// forced refreshes leave unexpired shared credentials alone, and that the cache is bounded
+//
+func TestAssumeRoleCache(t *testing.T) {
+
+	standIn := &stsStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	base := &AWSCred{
+		Project: "cache",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("AKID-base", "secret-base", ""),
+	}
+	opts := AssumeRoleOpts{RoleARN: "arn:aws:iam::111111111111:role/shared", Endpoint: server.URL}
+
+	first, err := base.AssumeRole(opts)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	second, err := base.AssumeRole(opts)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if first.Creds != second.Creds {
+		t.Fatal("credentials not shared", "stack", stack.Trace().TrimRuntime())
+	}
+
+	if _, err = first.refresh(false, 5*time.Minute); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if _, err = second.refresh(true, 5*time.Minute); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	standIn.Lock()
+	calls := len(standIn.calls)
+	standIn.Unlock()
+	if calls != 1 {
+		t.Fatal("unexpected number of STS calls", calls, "stack", stack.Trace().TrimRuntime())
+	}
+
+	named := opts
+	named.SessionName = "auditor"
+	windowed := opts
+	windowed.ExpiryWindow = time.Minute
+	for _, distinct := range []AssumeRoleOpts{named, windowed} {
+		other, err := base.AssumeRole(distinct)
+		if err != nil {
+			t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		if other.Creds == first.Creds {
+			t.Fatal("credentials shared", fmt.Sprintf("%+v", distinct), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+	auditor, err := base.AssumeRole(named)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	values, errGo := auditor.Creds.Get()
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	standIn.Lock()
+	last := standIn.calls[len(standIn.calls)-1]
+	standIn.Unlock()
+	if values.AccessKeyID != "AKID-shared" || last.sessionName != "auditor" {
+		t.Fatal("session name not used", fmt.Sprintf("%+v", last), "stack", stack.Trace().TrimRuntime())
+	}
+
+	for i := 0; i != roleCacheSize+10; i++ {
+		opts.RoleARN = fmt.Sprint("arn:aws:iam::111111111111:role/bounded-", i)
+		if _, err = base.AssumeRole(opts); err != nil {
+			t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+	roleGuard.Lock()
+	defer roleGuard.Unlock()
+	if len(roleCache) > roleCacheSize {
+		t.Fatal("role cache not bounded", len(roleCache), "stack", stack.Trace().TrimRuntime())
+	}
+}
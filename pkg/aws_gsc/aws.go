@@ -79,6 +79,18 @@ type AWSCred struct {
 	Project string
 	Region  string
 	Creds   *credentials.Credentials
+
+	// The files and profile the credentials were extracted from, retained so that
+	// the credentials can be watched for changes
+	files   []string
+	profile string
+	// sharedFile is the credentials file the Creds are loaded from using the profile, it
+	// is empty when the Creds were obtained some other way, for example by assuming a role
+	sharedFile string
+
+	// sources holds any credentials that were used to obtain the Creds, for example when
+	// a role has been assumed, innermost first
+	sources []*credentials.Credentials
 }
 
 // AWSExtractCreds can be used to populate a set of credentials from a pair of config and
@@ -90,6 +102,8 @@ func AWSExtractCreds(filenames []string, profile string) (cred *AWSCred, err kv.
 
 	cred = &AWSCred{
 		Project: "aws_" + filepath.Base(filepath.Dir(filenames[0])),
+		files:   append([]string{}, filenames...),
+		profile: profile,
 	}
 
 	credsDone := false
@@ -120,6 +134,7 @@ func AWSExtractCreds(filenames []string, profile string) (cred *AWSCred, err kv.
 
 		if !credsDone && !wasConfig {
 			cred.Creds = credentials.NewSharedCredentials(aFile, profile)
+			cred.sharedFile = aFile
 			credsDone = true
 		}
 	}
@@ -0,0 +1,281 @@
+// Copyright 2018-2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package aws_gsc
+
+// This file contains the implementation of helpers for generating SigV4 presigned URLs that
+// can be handed to users so that they can upload and download objects using S3 compatible
+// stores without having credentials of their own.  A verifier is also included that can be
+// used by tests to check presigned requests without needing a live service.
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+const (
+	// maxPresignExpiry is the longest lifetime SigV4 allows for a presigned URL
+	maxPresignExpiry = 7 * 24 * time.Hour
+
+	presignTimeFormat = "20060102T150405Z"
+)
+
+// PresignOpts contains the constraints that are applied to a presigned request
+//
+type PresignOpts struct {
+	// Endpoint can be used to specify a URL for non AWS stores such as minio, defaults to
+	// the AWS S3 endpoint for the region of the credentials
+	Endpoint string
+	// PathStyle forces the bucket to appear in the URL path rather than the host name, custom
+	// endpoints always use path style URLs
+	PathStyle bool
+	// Expiry is how long the URL remains valid for, defaults to 15 minutes
+	Expiry time.Duration
+	// ContentType, if set, must be sent by the user as the Content-Type header
+	ContentType string
+	// ContentMD5, if set, is the base64 encoded MD5 the uploaded content must match
+	ContentMD5 string
+	// ChecksumSHA256, if set, is the base64 encoded SHA256 the uploaded content must match
+	ChecksumSHA256 string
+}
+
+// Presigned contains a presigned request that can be handed to a user
+//
+type Presigned struct {
+	Method string
+	URL    string
+	// Header contains the headers that the user must send with the request
+	Header  http.Header
+	Expires time.Time
+}
+
+// PresignGet generates a presigned URL for downloading an object
+//
+func (cred *AWSCred) PresignGet(bucket string, key string, opts PresignOpts) (presigned *Presigned, err kv.Error) {
+	return cred.presign(http.MethodGet, bucket, key, url.Values{}, opts)
+}
+
+// PresignPut generates a presigned URL for uploading an object
+//
+func (cred *AWSCred) PresignPut(bucket string, key string, opts PresignOpts) (presigned *Presigned, err kv.Error) {
+	return cred.presign(http.MethodPut, bucket, key, url.Values{}, opts)
+}
+
+// PresignPart generates a presigned URL for uploading a single part of a multipart upload
+// that has already been created
+//
+func (cred *AWSCred) PresignPart(bucket string, key string, uploadID string, partNumber int, opts PresignOpts) (presigned *Presigned, err kv.Error) {
+	if len(uploadID) == 0 || partNumber < 1 || partNumber > 10000 {
+		return nil, kv.NewError("invalid multipart upload part").With("bucket", bucket, "key", key, "uploadID", uploadID, "partNumber", partNumber).With("stack", stack.Trace().TrimRuntime())
+	}
+	query := url.Values{
+		"partNumber": []string{strconv.Itoa(partNumber)},
+		"uploadId":   []string{uploadID},
+	}
+	return cred.presign(http.MethodPut, bucket, key, query, opts)
+}
+
+func (cred *AWSCred) objectURL(bucket string, key string, opts PresignOpts) (u *url.URL, err kv.Error) {
+	endpoint := opts.Endpoint
+	pathStyle := opts.PathStyle || len(endpoint) != 0
+	if len(endpoint) == 0 {
+		endpoint = "https://s3." + cred.Region + ".amazonaws.com"
+	}
+
+	u, errGo := url.Parse(endpoint)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("endpoint", endpoint).With("stack", stack.Trace().TrimRuntime())
+	}
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return nil, kv.NewError("invalid endpoint").With("endpoint", endpoint).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	if pathStyle {
+		path += "/" + bucket
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	path += "/" + key
+
+	u.Path = path
+	u.RawPath = escapePath(path)
+	return u, nil
+}
+
+// escapePath percent encodes a path as SigV4 expects, everything other than the RFC 3986
+// unreserved characters and the path separators is encoded.  url.PathEscape is not used as
+// it leaves sub-delimiters such as '+' and '=' unencoded.
+func escapePath(path string) (escaped string) {
+	buf := strings.Builder{}
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+			buf.WriteByte(c)
+		case c == '-', c == '_', c == '.', c == '~', c == '/':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func (cred *AWSCred) presign(method string, bucket string, key string, query url.Values, opts PresignOpts) (presigned *Presigned, err kv.Error) {
+
+	if len(bucket) == 0 || len(key) == 0 {
+		return nil, kv.NewError("bucket and key must be specified").With("bucket", bucket, "key", key).With("stack", stack.Trace().TrimRuntime())
+	}
+	if opts.Expiry == 0 {
+		opts.Expiry = 15 * time.Minute
+	}
+	if opts.Expiry < time.Second || opts.Expiry > maxPresignExpiry {
+		return nil, kv.NewError("invalid expiry").With("expiry", opts.Expiry.String()).With("stack", stack.Trace().TrimRuntime())
+	}
+	region := cred.Region
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+
+	u, err := cred.objectURL(bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+
+	req, errGo := http.NewRequest(method, u.String(), nil)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("bucket", bucket, "key", key).With("stack", stack.Trace().TrimRuntime())
+	}
+	if len(opts.ContentType) != 0 {
+		req.Header.Set("Content-Type", opts.ContentType)
+	}
+	if len(opts.ContentMD5) != 0 {
+		req.Header.Set("Content-Md5", opts.ContentMD5)
+	}
+	if len(opts.ChecksumSHA256) != 0 {
+		req.Header.Set("X-Amz-Checksum-Sha256", opts.ChecksumSHA256)
+	}
+
+	signTime := time.Now().UTC()
+	if _, errGo = newPresigner(cred.Creds).Presign(req, nil, "s3", region, opts.Expiry, signTime); errGo != nil {
+		return nil, kv.Wrap(errGo).With("bucket", bucket, "key", key).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	return &Presigned{
+		Method:  method,
+		URL:     req.URL.String(),
+		Header:  req.Header,
+		Expires: signTime.Truncate(time.Second).Add(opts.Expiry),
+	}, nil
+}
+
+func newPresigner(creds *credentials.Credentials) (signer *v4.Signer) {
+	return v4.NewSigner(creds, func(s *v4.Signer) {
+		// S3 does not double escape paths, and the content constraints are kept as
+		// headers that the user must send rather than being hoisted into the query
+		s.DisableURIPathEscaping = true
+		s.DisableHeaderHoisting = true
+	})
+}
+
+// VerifyPresigned checks that a request was presigned using the receivers credentials, has
+// not expired at the time now, and carries the headers that were included in the signature.
+//
+func (cred *AWSCred) VerifyPresigned(r *http.Request, now time.Time) (err kv.Error) {
+
+	query := r.URL.Query()
+
+	if algo := query.Get("X-Amz-Algorithm"); algo != "AWS4-HMAC-SHA256" {
+		return kv.NewError("unsupported signing algorithm").With("algorithm", algo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// The credential is formatted as AKID/date/region/service/aws4_request
+	scope := strings.Split(query.Get("X-Amz-Credential"), "/")
+	if len(scope) != 5 || scope[4] != "aws4_request" {
+		return kv.NewError("malformed credential scope").With("credential", query.Get("X-Amz-Credential")).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	values, errGo := cred.Creds.Get()
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	if scope[0] != values.AccessKeyID {
+		return kv.NewError("access key mismatch").With("access_key", scope[0]).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	signTime, errGo := time.Parse(presignTimeFormat, query.Get("X-Amz-Date"))
+	if errGo != nil {
+		return kv.Wrap(errGo).With("date", query.Get("X-Amz-Date")).With("stack", stack.Trace().TrimRuntime())
+	}
+	if scope[1] != signTime.Format("20060102") {
+		return kv.NewError("credential scope date mismatch").With("scope", scope[1], "date", query.Get("X-Amz-Date")).With("stack", stack.Trace().TrimRuntime())
+	}
+	expires, errGo := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if errGo != nil || expires < 1 || time.Duration(expires)*time.Second > maxPresignExpiry {
+		return kv.NewError("invalid expiry").With("expires", query.Get("X-Amz-Expires")).With("stack", stack.Trace().TrimRuntime())
+	}
+	expiry := time.Duration(expires) * time.Second
+	if now.Before(signTime.Add(-5 * time.Minute)) {
+		return kv.NewError("request not yet valid").With("date", query.Get("X-Amz-Date")).With("stack", stack.Trace().TrimRuntime())
+	}
+	if now.After(signTime.Add(expiry)) {
+		return kv.NewError("request expired").With("date", query.Get("X-Amz-Date"), "expires", expires).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	signature := query.Get("X-Amz-Signature")
+	if len(signature) == 0 {
+		return kv.NewError("request not signed").With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// Rebuild the request without any of the presigning values using only the signed headers,
+	// and then sign it again using the original signing time for comparison
+	for _, k := range []string{"X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date", "X-Amz-Expires",
+		"X-Amz-SignedHeaders", "X-Amz-Signature", "X-Amz-Security-Token"} {
+		query.Del(k)
+	}
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	u.Host = r.Host
+	if len(u.Host) == 0 {
+		u.Host = r.URL.Host
+	}
+	if len(u.Scheme) == 0 {
+		u.Scheme = "http"
+	}
+
+	check, errGo := http.NewRequest(r.Method, u.String(), nil)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	for _, k := range strings.Split(r.URL.Query().Get("X-Amz-SignedHeaders"), ";") {
+		if k == "host" {
+			continue
+		}
+		v, isPresent := r.Header[http.CanonicalHeaderKey(k)]
+		if !isPresent {
+			return kv.NewError("signed header missing").With("header", k).With("stack", stack.Trace().TrimRuntime())
+		}
+		check.Header[http.CanonicalHeaderKey(k)] = v
+	}
+
+	if _, errGo = newPresigner(cred.Creds).Presign(check, nil, scope[3], scope[2], expiry, signTime); errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	if !hmac.Equal([]byte(check.URL.Query().Get("X-Amz-Signature")), []byte(signature)) {
+		return kv.NewError("signature mismatch").With("method", r.Method, "path", r.URL.Path).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
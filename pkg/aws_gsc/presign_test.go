@@ -0,0 +1,156 @@
+// Copyright 2018-2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package aws_gsc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+)
+
+func presignedRequest(t *testing.T, presigned *Presigned, body []byte) (req *http.Request) {
+	req, errGo := http.NewRequest(presigned.Method, presigned.URL, bytes.NewReader(body))
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	for k, v := range presigned.Header {
+		req.Header[k] = v
+	}
+	return req
+}
+
+// TestPresignVerify checks that presigned requests pass verification only when they
+// are unaltered, carry their constrained headers and have not expired
+//
+func TestPresignVerify(t *testing.T) {
+
+	cred := &AWSCred{
+		Project: "presign",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("presign_access_key", "presign_secret_key", ""),
+	}
+
+	content := []byte("experiment artifact")
+	sum := sha256.Sum256(content)
+	opts := PresignOpts{
+		Endpoint:       "http://127.0.0.1:9000",
+		Expiry:         time.Minute,
+		ContentType:    "application/octet-stream",
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(sum[:]),
+	}
+
+	presigned, err := cred.PresignPut("artifacts", "experiments/1/output file.tar", opts)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if !strings.HasPrefix(presigned.URL, "http://127.0.0.1:9000/artifacts/experiments/1/output%20file.tar?") {
+		t.Fatal("unexpected URL", presigned.URL, "stack", stack.Trace().TrimRuntime())
+	}
+
+	if err = cred.VerifyPresigned(presignedRequest(t, presigned, content), time.Now()); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// A different content type must be rejected
+	req := presignedRequest(t, presigned, content)
+	req.Header.Set("Content-Type", "text/plain")
+	if err = cred.VerifyPresigned(req, time.Now()); err == nil {
+		t.Fatal("altered content type was accepted", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// A missing checksum must be rejected
+	req = presignedRequest(t, presigned, content)
+	req.Header.Del("X-Amz-Checksum-Sha256")
+	if err = cred.VerifyPresigned(req, time.Now()); err == nil {
+		t.Fatal("missing checksum was accepted", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// An altered key must be rejected
+	req = presignedRequest(t, presigned, content)
+	req.URL.Path = "/artifacts/experiments/2/output file.tar"
+	req.URL.RawPath = ""
+	if err = cred.VerifyPresigned(req, time.Now()); err == nil {
+		t.Fatal("altered key was accepted", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Expired requests must be rejected
+	if err = cred.VerifyPresigned(presignedRequest(t, presigned, content), time.Now().Add(2*time.Minute)); err == nil {
+		t.Fatal("expired request was accepted", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Requests signed by other credentials must be rejected
+	other := &AWSCred{
+		Region: "us-west-2",
+		Creds:  credentials.NewStaticCredentials("presign_access_key", "another_secret_key", ""),
+	}
+	if err = other.VerifyPresigned(presignedRequest(t, presigned, content), time.Now()); err == nil {
+		t.Fatal("request signed with another secret was accepted", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestPresignServer checks presigned GET and multipart part URLs against a local server
+// that uses the verifier
+//
+func TestPresignServer(t *testing.T) {
+
+	cred := &AWSCred{
+		Project: "presign",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("presign_access_key", "presign_secret_key", "session_token"),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := cred.VerifyPresigned(r, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	get, err := cred.PresignGet("artifacts", "experiments/1/metadata.json", PresignOpts{Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	part, err := cred.PresignPart("artifacts", "experiments/1/output.tar", "upload-id", 3, PresignOpts{Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if !strings.Contains(part.URL, "partNumber=3") || !strings.Contains(part.URL, "uploadId=upload-id") {
+		t.Fatal("unexpected part URL", part.URL, "stack", stack.Trace().TrimRuntime())
+	}
+
+	for _, presigned := range []*Presigned{get, part} {
+		resp, errGo := http.DefaultClient.Do(presignedRequest(t, presigned, []byte("part")))
+		if errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("presigned request rejected", presigned.Method, resp.Status, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestEscapePath checks that keys are encoded as SigV4 expects, including the sub-delimiters
+// that url.PathEscape leaves alone
+//
+func TestEscapePath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/bucket/a b+c=d.tar": "/bucket/a%20b%2Bc%3Dd.tar",
+		"/bucket/x~y_z-1/2":   "/bucket/x~y_z-1/2",
+		"/bucket/café:@!":     "/bucket/caf%C3%A9%3A%40%21",
+	} {
+		if escaped := escapePath(path); escaped != expected {
+			t.Fatal("unexpected escaping", path, escaped, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
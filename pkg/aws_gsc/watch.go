@@ -0,0 +1,195 @@
+// Copyright 2018-2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package aws_gsc
+
+// This file contains the implementation of a watcher for AWS credentials.  Long running
+// services will often have their shared credentials files rotated underneath them by
+// sidecars, or will be using session credentials that expire.  The watcher reloads
+// credentials when the files change and proactively refreshes credentials that are
+// approaching their expiry time.
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/lthibault/jitterbug"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/components"
+)
+
+// CredsUpdate is used to publish the details of a change to a set of watched credentials
+//
+type CredsUpdate struct {
+	Project string
+	Region  string
+	Value   credentials.Value
+	Expires time.Time // The zero time is used when the expiry of the credentials is not known
+}
+
+// CredsWatchOpts contains the tuneable parameters of a credentials watcher
+//
+type CredsWatchOpts struct {
+	// Interval is the period between checks of the credentials files and expiry time
+	Interval time.Duration
+	// ExpiryWindow is how long before the credentials expire that they will be refreshed
+	ExpiryWindow time.Duration
+	// Module is the name the watcher uses when reporting its health to components
+	Module string
+}
+
+// fileSig is used to detect changes to a file without needing to read its contents
+type fileSig struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func sigFiles(files []string) (sigs map[string]fileSig) {
+	sigs = make(map[string]fileSig, len(files))
+	for _, fn := range files {
+		fi, errGo := os.Stat(fn)
+		if errGo != nil {
+			sigs[fn] = fileSig{}
+			continue
+		}
+		sigs[fn] = fileSig{
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			exists:  true,
+		}
+	}
+	return sigs
+}
+
+// WatchCreds is used to monitor the credentials for changes to the files they were loaded from
+// and to refresh them before they expire.  When the credentials change the new values are sent
+// to the updateC channel, and any failures to load them are sent to the errorC channel.  If
+// the comps parameter is supplied the health of the credentials is reported using the module name
+// from the options.
+//
+// This is a blocking function that will return when the ctx is Done().
+//
+func (cred *AWSCred) WatchCreds(ctx context.Context, opts CredsWatchOpts, comps *components.Components, updateC chan<- CredsUpdate, errorC chan<- kv.Error) {
+
+	if opts.Interval <= 0 {
+		opts.Interval = 15 * time.Second
+	}
+	if opts.ExpiryWindow <= 0 {
+		opts.ExpiryWindow = 5 * time.Minute
+	}
+	if len(opts.Module) == 0 {
+		opts.Module = "aws_creds_" + cred.Project
+	}
+
+	sigs := sigFiles(cred.files)
+	last, err := cred.refresh(false, opts.ExpiryWindow)
+	healthy := err == nil
+
+	report := func(err kv.Error) {
+		if comps != nil {
+			comps.SetModule(opts.Module, err == nil)
+		}
+		if err == nil || errorC == nil {
+			return
+		}
+		select {
+		case errorC <- err:
+		case <-time.After(time.Second):
+		}
+	}
+	report(err)
+
+	check := jitterbug.New(opts.Interval, &jitterbug.Norm{Stdev: opts.Interval / 10})
+	defer check.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+			changed := false
+			newSigs := sigFiles(cred.files)
+			for fn, sig := range newSigs {
+				if sig != sigs[fn] {
+					changed = true
+				}
+			}
+			sigs = newSigs
+
+			if expires, errGo := cred.Creds.ExpiresAt(); errGo == nil {
+				if time.Until(expires) < opts.ExpiryWindow {
+					changed = true
+				}
+			}
+
+			// Unhealthy credentials are retried on every check
+			if !changed && healthy {
+				continue
+			}
+
+			update, err := cred.refresh(true, opts.ExpiryWindow)
+			healthy = err == nil
+			report(err)
+			if err != nil {
+				continue
+			}
+
+			if update.Value == last.Value && update.Region == last.Region {
+				continue
+			}
+			last = update
+
+			if updateC == nil {
+				continue
+			}
+			select {
+			case updateC <- update:
+			case <-time.After(2 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// refresh will retrieve the credential values, optionally forcing them to be reloaded from
+// their provider.  Credentials with an expiry time, such as assumed roles, can be shared with
+// other users through the role cache so they are only forced when within the expiry window.
+func (cred *AWSCred) refresh(force bool, expiryWindow time.Duration) (update CredsUpdate, err kv.Error) {
+	update = CredsUpdate{
+		Project: cred.Project,
+		Region:  cred.Region,
+	}
+
+	if force {
+		for _, creds := range append(append([]*credentials.Credentials{}, cred.sources...), cred.Creds) {
+			if expires, errGo := creds.ExpiresAt(); errGo == nil && time.Until(expires) >= expiryWindow {
+				continue
+			}
+			creds.Expire()
+		}
+	}
+
+	values, errGo := cred.Creds.Get()
+	if errGo != nil {
+		return update, kv.Wrap(errGo).With("project", cred.Project, "files", cred.files).With("stack", stack.Trace().TrimRuntime())
+	}
+	update.Value = values
+
+	if expires, errGo := cred.Creds.ExpiresAt(); errGo == nil {
+		update.Expires = expires
+	}
+
+	// The region is only reloaded from the configuration files when they are available
+	if force && len(cred.files) != 0 {
+		if fresh, err := AWSExtractCreds(cred.files, cred.profile); err == nil {
+			update.Region = fresh.Region
+		}
+	}
+	return update, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2018-2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package aws_gsc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+// TestWatchCredsRotation checks that rewriting a credentials file results in an update being
+// published with the new keys
+//
+func TestWatchCredsRotation(t *testing.T) {
+
+	tmpDir, errGo := ioutil.TempDir("", "TestWatchCredsRotation")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFN := filepath.Join(tmpDir, "config")
+	credsFN := filepath.Join(tmpDir, "credentials")
+
+	if errGo := ioutil.WriteFile(configFN, []byte("[default]\nregion=us-west-2\n"), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "fn", configFN, "stack", stack.Trace().TrimRuntime())
+	}
+	if errGo := ioutil.WriteFile(credsFN, []byte("[default]\naws_access_key_id=first\naws_secret_access_key=first_secret\n"), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "fn", credsFN, "stack", stack.Trace().TrimRuntime())
+	}
+
+	cred, err := AWSExtractCreds([]string{configFN, credsFN}, "default")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	updateC := make(chan CredsUpdate, 1)
+	errorC := make(chan kv.Error, 1)
+	go cred.WatchCreds(ctx, CredsWatchOpts{Interval: 20 * time.Millisecond}, nil, updateC, errorC)
+
+	// Give the watcher a chance to capture the initial file state, then rotate the keys
+	time.Sleep(100 * time.Millisecond)
+	if errGo := ioutil.WriteFile(credsFN, []byte("[default]\naws_access_key_id=second\naws_secret_access_key=second_secret_rotated\n"), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "fn", credsFN, "stack", stack.Trace().TrimRuntime())
+	}
+
+	select {
+	case update := <-updateC:
+		if update.Value.AccessKeyID != "second" {
+			t.Fatal("unexpected access key", update.Value.AccessKeyID, "stack", stack.Trace().TrimRuntime())
+		}
+		if update.Region != "us-west-2" {
+			t.Fatal("unexpected region", update.Region, "stack", stack.Trace().TrimRuntime())
+		}
+	case err := <-errorC:
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	case <-ctx.Done():
+		t.Fatal("credentials rotation was not detected", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// expiringProvider hands out credentials that expire shortly after being retrieved
+type expiringProvider struct {
+	credentials.Expiry
+	generation int32
+	lifetime   time.Duration
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	gen := atomic.AddInt32(&p.generation, 1)
+	p.SetExpiration(time.Now().Add(p.lifetime), 0)
+	return credentials.Value{
+		AccessKeyID:     "expiring",
+		SecretAccessKey: "secret",
+		SessionToken:    string(rune('a' + gen)),
+	}, nil
+}
+
+// TestWatchCredsExpiry checks that credentials nearing their expiry are refreshed before
+// they expire
+//
+func TestWatchCredsExpiry(t *testing.T) {
+
+	provider := &expiringProvider{lifetime: time.Second}
+	cred := &AWSCred{
+		Project: "expiry",
+		Creds:   credentials.NewCredentials(provider),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	updateC := make(chan CredsUpdate, 1)
+	go cred.WatchCreds(ctx, CredsWatchOpts{Interval: 20 * time.Millisecond, ExpiryWindow: 500 * time.Millisecond}, nil, updateC, nil)
+
+	select {
+	case update := <-updateC:
+		if update.Expires.IsZero() {
+			t.Fatal("expiry time was not published", "stack", stack.Trace().TrimRuntime())
+		}
+		// The refresh must occur ahead of the original credentials expiring
+		if atomic.LoadInt32(&provider.generation) < 2 {
+			t.Fatal("credentials were not refreshed", "stack", stack.Trace().TrimRuntime())
+		}
+	case <-ctx.Done():
+		t.Fatal("credentials were not refreshed before expiry", "stack", stack.Trace().TrimRuntime())
+	}
+}
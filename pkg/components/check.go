@@ -0,0 +1,128 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains the implementation of active health checks.  Rather than relying on a module
+// to report its own state, a check function is registered and run periodically by the tracker with
+// its result being used to set the state of the module.
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jjeffery/kv" // MIT License
+	"github.com/lthibault/jitterbug"
+)
+
+// CheckFunc tests the health of a module, returning nil when the module is up.  The ctx is
+// cancelled when the check times out.
+//
+type CheckFunc func(ctx context.Context) (err kv.Error)
+
+// CheckOpts contains the parameters used to run a check, zero values are replaced with defaults
+//
+type CheckOpts struct {
+	// Interval is the period between checks, defaults to 10 seconds, a random jitter of
+	// 10% is applied to spread checks out
+	Interval time.Duration
+	// Timeout is how long a check is allowed to run before it is counted as a failure,
+	// defaults to the interval
+	Timeout time.Duration
+	// FailureThreshold is the number of consecutive failures needed to mark the module down,
+	// defaults to 1
+	FailureThreshold int
+	// SuccessThreshold is the number of consecutive successes needed to mark the module up,
+	// defaults to 1
+	SuccessThreshold int
+}
+
+// AddCheck registers a check that is run until the tracking context is Done() or the returned
+// remove function is called.  The module is Unknown until enough checks have completed to
+// meet one of the thresholds.
+//
+func (comps *Components) AddCheck(module string, opts CheckOpts, check CheckFunc) (remove func()) {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Timeout <= 0 || opts.Timeout > opts.Interval {
+		opts.Timeout = opts.Interval
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 1
+	}
+	if opts.SuccessThreshold <= 0 {
+		opts.SuccessThreshold = 1
+	}
+
+	comps.SetStatus(module, Unknown, "awaiting check", nil)
+
+	ctx, cancel := context.WithCancel(comps.ctx)
+	go comps.runCheck(ctx, module, opts, check)
+
+	return cancel
+}
+
+// runCheck calls a check function on a jittered interval and applies the thresholds to its results
+func (comps *Components) runCheck(ctx context.Context, module string, opts CheckOpts, check CheckFunc) {
+
+	successes := 0
+	failures := 0
+
+	// resultC is only ever used by one check at a time, runs that fall due while a check is
+	// stuck in a function that ignores its context are skipped
+	resultC := make(chan kv.Error, 1)
+	running := false
+	var timeoutC <-chan time.Time
+
+	start := func() {
+		if running {
+			return
+		}
+		running = true
+		timeoutC = time.After(opts.Timeout)
+		go func() {
+			checkCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+			resultC <- check(checkCtx)
+		}()
+	}
+
+	record := func(err kv.Error) {
+		if err == nil {
+			failures = 0
+			if successes++; successes >= opts.SuccessThreshold {
+				comps.SetStatus(module, Up, "", nil)
+			}
+			return
+		}
+		successes = 0
+		if failures++; failures >= opts.FailureThreshold {
+			comps.SetStatus(module, Down, err.Error(), map[string]string{"failures": strconv.Itoa(failures)})
+		}
+	}
+
+	t := jitterbug.New(opts.Interval, &jitterbug.Norm{Stdev: opts.Interval / 10})
+	defer t.Stop()
+
+	start()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-resultC:
+			running = false
+			// Results arriving after the check was counted as timed out are ignored
+			if timeoutC != nil {
+				timeoutC = nil
+				record(err)
+			}
+		case <-timeoutC:
+			timeoutC = nil
+			// The error is used as the reason for the module being down and so a stack is not included
+			record(kv.NewError("check timed out").With("timeout", opts.Timeout.String()))
+		case <-t.C:
+			start()
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+func waitStatus(ctx context.Context, t *testing.T, comps *Components, module string, status Status) (state ModuleState) {
+	for {
+		if state = comps.Snapshot().Modules[module]; state.Status == status {
+			return state
+		}
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("module did not reach status", module, status, state, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestChecks checks that registered checks drive module state using their thresholds
+//
+func TestChecks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+
+	healthy := int32(1)
+	calls := int32(0)
+	remove := comps.AddCheck("database", CheckOpts{
+		Interval:         20 * time.Millisecond,
+		FailureThreshold: 3,
+		SuccessThreshold: 2,
+	}, func(ctx context.Context) (err kv.Error) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			return kv.NewError("connection refused")
+		}
+		return nil
+	})
+
+	if state := comps.Snapshot().Modules["database"]; state.Status != Unknown {
+		t.Fatal("check was not registered as unknown", state, "stack", stack.Trace().TrimRuntime())
+	}
+	waitStatus(ctx, t, comps, "database", Up)
+	if atomic.LoadInt32(&calls) < 2 {
+		t.Fatal("module up before the success threshold", calls, "stack", stack.Trace().TrimRuntime())
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	failedAt := atomic.LoadInt32(&calls)
+	state := waitStatus(ctx, t, comps, "database", Down)
+	if atomic.LoadInt32(&calls)-failedAt < 3 || state.Reason != "connection refused" || state.Details["failures"] != "3" {
+		t.Fatal("module down before the failure threshold", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	waitStatus(ctx, t, comps, "database", Up)
+
+	// Removing the check stops it from running
+	remove()
+	time.Sleep(50 * time.Millisecond)
+	stoppedAt := atomic.LoadInt32(&calls)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&calls) != stoppedAt {
+		t.Fatal("check ran after being removed", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Checks that hang beyond their timeout are failed without waiting for them
+	releaseC := make(chan struct{})
+	defer close(releaseC)
+	comps.AddCheck("hung", CheckOpts{
+		Interval: 50 * time.Millisecond,
+		Timeout:  20 * time.Millisecond,
+	}, func(ctx context.Context) (err kv.Error) {
+		<-releaseC
+		return nil
+	})
+	if state = waitStatus(ctx, t, comps, "hung", Down); !strings.HasPrefix(state.Reason, "check timed out") {
+		t.Fatal("unexpected reason", state, "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -2,7 +2,7 @@
 
 package components // import "github.com/leaf-ai/go-service/pkg/components"
 
-// This file contains the implementation of a map of strings and booleans that are updated
+// This file contains the implementation of a map of module names and their health that is updated
 // by a servers critical components.  Clients can also request that if any of the components goes into
 // a down (false) condition that they will be informed using a channel and when the entire collection
 // goes into a true condition that they will also be informed.
@@ -14,60 +14,157 @@ import (
 	"sync"
 	"time"
 
+	"github.com/leaf-ai/go-service/pkg/log"
+
 	"golang.org/x/net/context"
 )
 
+// Components holds the health of the modules within a server and informs listeners of
+// the aggregate state
+//
 type Components struct {
 	listeners    []chan bool
-	components   map[string]bool
+	components   map[string]*ModuleState
+	config       map[string]*moduleConfig
+	subscribers  map[*Subscription]struct{}
+	published    map[string]ModuleState // The module states last sent to subscribers
+	hysteresis   HysteresisOpts
+	damping      map[string]*damping
+	aggregate    aggregate
+	history      map[string]*ring
+	historySize  int
+	logger       *log.Logger  // Receives transitions when set
+	unlogged     []Transition // Transitions waiting to be logged once the lock is released
+	logging      bool         // Set while a caller is logging transitions, keeping them in order
 	clientUpdate chan struct{}
+	// ctx is the tracking context, used to stop checks run by the tracker
+	ctx context.Context
 	sync.Mutex
 }
 
+// AddListener adds a channel that periodically receives the aggregate state of the server,
+// and receives it when any module changes.  Sends that are not received within 20ms are
+// skipped, subscriptions can be used when every change must be seen.
+//
 func (comps *Components) AddListener(listener chan bool) {
 	comps.Lock()
 	defer comps.Unlock()
 	comps.listeners = append(comps.listeners, listener)
 }
 
+// RemoveListener stops sending the aggregate state to a listener, closing the listener also
+// removes it
+//
+func (comps *Components) RemoveListener(listener chan bool) {
+	comps.Lock()
+	defer comps.Unlock()
+	for i, existing := range comps.listeners {
+		if existing == listener {
+			comps.listeners = append(comps.listeners[:i], comps.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetModule records a module as being either Up or Down
+//
 func (comps *Components) SetModule(module string, up bool) {
+	status := Down
+	if up {
+		status = Up
+	}
+	comps.SetStatus(module, status, "", nil)
+}
+
+// SetStatus records the status of a module along with a reason and optional details, the
+// details replace any previously recorded
+//
+func (comps *Components) SetStatus(module string, status Status, reason string, details map[string]string) {
+	now := time.Now()
+
+	// Deferred first so that it runs after the lock has been released
+	defer comps.logTransitions()
+
 	comps.Lock()
 	defer comps.Unlock()
-	comps.components[module] = up
+
+	d := comps.dampingOf(module)
+	if _, isPresent := comps.components[module]; isPresent && d.reported.IsUp() != status.IsUp() {
+		d.transitions = append(d.transitions, now)
+	}
+	d.reported = status
+	d.reason = reason
+	d.details = copyDetails(details)
+	if status.IsUp() {
+		d.successes++
+	} else {
+		d.successes = 0
+	}
+
+	comps.record(module, now)
+	comps.changed()
+}
+
+// signal wakes the update loop so that listeners learn of changes promptly
+func (comps *Components) signal() {
 	select {
 	case comps.clientUpdate <- struct{}{}:
 	default:
 	}
 }
 
-func (comps *Components) doUpdate() {
+// Snapshot returns a copy of the state of every module that has reported, modules blocked by
+// a dependency are reported as Down
+//
+func (comps *Components) Snapshot() (snap *Snapshot) {
 	comps.Lock()
 	defer comps.Unlock()
 
-	// Is the sever entirely up or not
-	up := true
-	for _, v := range comps.components {
-		if !v {
-			up = false
-			break
+	modules := comps.resolve()
+	return &Snapshot{
+		Up:      comps.reportedUp(modules),
+		Taken:   time.Now(),
+		Modules: modules,
+	}
+}
+
+// SetLiveness marks a module as one whose failure means the server must be restarted, rather
+// than only being unable to accept work.  Liveness modules are reported by both liveness and
+// readiness probes, all other modules by readiness probes only.
+//
+func (comps *Components) SetLiveness(module string, liveness bool) {
+	comps.Lock()
+	defer comps.Unlock()
+	comps.configOf(module).liveness = liveness
+	comps.changed()
+}
+
+// isUp returns the aggregate state of resolved modules, only critical modules are considered
+func isUp(modules map[string]ModuleState) (up bool) {
+	for _, state := range modules {
+		if state.Class == Critical && !state.Status.IsUp() {
+			return false
 		}
 	}
+	return true
+}
+
+func (comps *Components) doUpdate() {
+	comps.Lock()
+	defer comps.Unlock()
+
+	// Is the sever entirely up or not
+	up := comps.advanceAggregate(comps.resolve())
 
 	// Tell everyone what the collective state is for the server
+	closed := []int{}
 	for i, listener := range comps.listeners {
 		func() {
 			defer func() {
 				// A send to a closed channel will panic and so if a
 				// panic does occur we remove the listener
 				if r := recover(); r != nil {
-					comps.Lock()
-					defer comps.Unlock()
-
-					if len(comps.listeners) <= 1 {
-						comps.listeners = []chan bool{}
-						return
-					}
-					comps.listeners = append(comps.listeners[:i], comps.listeners[i+1:]...)
+					closed = append(closed, i)
 				}
 			}()
 			select {
@@ -76,13 +173,24 @@ func (comps *Components) doUpdate() {
 			}
 		}()
 	}
+
+	// Remove closed listeners working backwards so that the indexes remain valid
+	for i := len(closed) - 1; i >= 0; i-- {
+		comps.listeners = append(comps.listeners[:closed[i]], comps.listeners[closed[i]+1:]...)
+	}
 }
 
 func InitComponentTracking(ctx context.Context) (comps *Components) {
 	comps = &Components{
 		listeners:    []chan bool{},
-		components:   map[string]bool{},
-		clientUpdate: make(chan struct{}),
+		components:   map[string]*ModuleState{},
+		config:       map[string]*moduleConfig{},
+		subscribers:  map[*Subscription]struct{}{},
+		damping:      map[string]*damping{},
+		history:      map[string]*ring{},
+		historySize:  DefaultHistorySize,
+		clientUpdate: make(chan struct{}, 1), // Coalesces updates without losing any made during doUpdate
+		ctx:          ctx,
 	}
 
 	go func(comps *Components) {
@@ -0,0 +1,94 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+)
+
+func waitListener(ctx context.Context, t *testing.T, listener chan bool, expected bool) {
+	for {
+		select {
+		case up := <-listener:
+			if up == expected {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("listener did not receive state", expected, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestStatus checks that module statuses are recorded with their reasons and transitions
+// while listeners continue to receive the aggregate state
+//
+func TestStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+
+	listener := make(chan bool, 1)
+	comps.AddListener(listener)
+
+	comps.SetModule("queue", true)
+	comps.SetStatus("storage", Degraded, "high latency", map[string]string{"endpoint": "s3"})
+	waitListener(ctx, t, listener, true)
+
+	snap := comps.Snapshot()
+	if !snap.Up || len(snap.Modules) != 2 || len(snap.Down()) != 0 {
+		t.Fatal("unexpected snapshot", snap, "stack", stack.Trace().TrimRuntime())
+	}
+	storage := snap.Modules["storage"]
+	if storage.Status != Degraded || storage.Reason != "high latency" || storage.Details["endpoint"] != "s3" {
+		t.Fatal("unexpected module state", storage, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Snapshots are copies that are unaffected by later changes
+	storage.Details["endpoint"] = "changed"
+	if comps.Snapshot().Modules["storage"].Details["endpoint"] != "s3" {
+		t.Fatal("snapshot shares details with the tracker", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Reporting the same status keeps the transition time
+	time.Sleep(10 * time.Millisecond)
+	comps.SetStatus("storage", Degraded, "still slow", nil)
+	if after := comps.Snapshot().Modules["storage"]; !after.Since.Equal(storage.Since) || !after.Updated.After(storage.Updated) {
+		t.Fatal("transition time changed without a transition", after, "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps.SetStatus("storage", Down, "bucket missing", nil)
+	waitListener(ctx, t, listener, false)
+
+	snap = comps.Snapshot()
+	if snap.Up || len(snap.Down()) != 1 || snap.Down()[0] != "storage" {
+		t.Fatal("unexpected snapshot", snap, "stack", stack.Trace().TrimRuntime())
+	}
+	if down := snap.Modules["storage"]; !down.Since.After(storage.Since) || down.Reason != "bucket missing" {
+		t.Fatal("transition not recorded", down, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Modules that have not reported are counted as down
+	comps.SetStatus("storage", Up, "", nil)
+	comps.SetStatus("database", Unknown, "not yet connected", nil)
+	if comps.Snapshot().Up {
+		t.Fatal("unknown module counted as up", "stack", stack.Trace().TrimRuntime())
+	}
+
+	encoded, errGo := json.Marshal(comps.Snapshot())
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	decoded := &Snapshot{}
+	if errGo = json.Unmarshal(encoded, decoded); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if decoded.Modules["database"].Status != Unknown || decoded.Modules["storage"].Status != Up {
+		t.Fatal("snapshot did not survive encoding", string(encoded), "stack", stack.Trace().TrimRuntime())
+	}
+}
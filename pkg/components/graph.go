@@ -0,0 +1,216 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains the classification of modules as critical or optional, and the dependencies
+// declared between modules.  A module that is up but depends on a module that is not up is
+// reported as down, and only critical modules are considered when deciding if the server is up.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// Class determines if a module contributes to the aggregate state of the server
+//
+type Class int
+
+const (
+	// Critical modules must be up for the server to be up, this is the default
+	Critical Class = iota
+	// Optional modules are reported but do not affect the aggregate state
+	Optional
+)
+
+// String returns the lower case name of the class
+//
+func (c Class) String() string {
+	if c == Optional {
+		return "optional"
+	}
+	return "critical"
+}
+
+// MarshalText encodes the class using its name
+//
+func (c Class) MarshalText() (text []byte, errGo error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText decodes a class name
+//
+func (c *Class) UnmarshalText(text []byte) (errGo error) {
+	switch strings.ToLower(string(text)) {
+	case "critical":
+		*c = Critical
+	case "optional":
+		*c = Optional
+	default:
+		return kv.NewError("unknown class").With("class", string(text)).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// moduleConfig holds the declarations made about a module, independently of its reported state
+type moduleConfig struct {
+	liveness  bool
+	class     Class
+	dependsOn []string
+}
+
+// configOf returns the declarations for a module, creating them if needed, the caller is
+// expected to be holding the lock
+func (comps *Components) configOf(module string) (config *moduleConfig) {
+	config, isPresent := comps.config[module]
+	if !isPresent {
+		config = &moduleConfig{}
+		comps.config[module] = config
+	}
+	return config
+}
+
+// SetClass classifies a module as being Critical or Optional
+//
+func (comps *Components) SetClass(module string, class Class) {
+	comps.Lock()
+	defer comps.Unlock()
+	comps.configOf(module).class = class
+	comps.changed()
+}
+
+// DependsOn declares that a module can only be up when all of the modules it depends upon are
+// up.  If any of the dependencies would create a cycle none of them are added.
+//
+func (comps *Components) DependsOn(module string, dependencies ...string) (err kv.Error) {
+	comps.Lock()
+	defer comps.Unlock()
+
+	// Every new dependency starts from the module, so they can only create a cycle through
+	// the dependencies already declared and are checked before any are added
+	for _, dep := range dependencies {
+		if dep == module || comps.reaches(dep, module, map[string]bool{}) {
+			return kv.NewError("dependency cycle").With("module", module, "dependency", dep).With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	config := comps.configOf(module)
+	for _, dep := range dependencies {
+		for _, existing := range config.dependsOn {
+			if existing == dep {
+				dep = ""
+				break
+			}
+		}
+		if len(dep) != 0 {
+			config.dependsOn = append(config.dependsOn, dep)
+		}
+	}
+
+	comps.changed()
+	return nil
+}
+
+// reaches tests if the target can be reached by following the dependencies of a module, the
+// caller is expected to be holding the lock
+func (comps *Components) reaches(module string, target string, visited map[string]bool) bool {
+	if visited[module] {
+		return false
+	}
+	visited[module] = true
+
+	config, isPresent := comps.config[module]
+	if !isPresent {
+		return false
+	}
+	for _, dep := range config.dependsOn {
+		if dep == target || comps.reaches(dep, target, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns copies of the module states with their declarations applied, and with modules
+// whose dependencies are not up reported as down.  Modules that have been declared but have not
+// reported are not included.  The caller is expected to be holding the lock.
+func (comps *Components) resolve() (modules map[string]ModuleState) {
+	modules = make(map[string]ModuleState, len(comps.components))
+
+	var visit func(name string) (up bool)
+	visit = func(name string) (up bool) {
+		if state, isPresent := modules[name]; isPresent {
+			return state.Status.IsUp()
+		}
+		reported, isPresent := comps.components[name]
+		if !isPresent {
+			return false
+		}
+		state := reported.clone()
+		state.Reported = state.Status
+
+		if config, isPresent := comps.config[name]; isPresent {
+			state.Liveness = config.liveness
+			state.Class = config.class
+			if len(config.dependsOn) != 0 {
+				state.DependsOn = append([]string{}, config.dependsOn...)
+			}
+			for _, dep := range config.dependsOn {
+				if !visit(dep) {
+					state.BlockedBy = append(state.BlockedBy, dep)
+				}
+			}
+		}
+		if len(state.BlockedBy) != 0 && state.Status.IsUp() {
+			state.Status = Down
+			state.Reason = "dependency down: " + strings.Join(state.BlockedBy, ", ")
+		}
+
+		modules[name] = state
+		return state.Status.IsUp()
+	}
+
+	for name := range comps.components {
+		visit(name)
+	}
+	return modules
+}
+
+// Graph renders the modules and their dependencies using the Graphviz DOT language, modules
+// that are down are drawn in red and optional modules are dashed
+//
+func (snap *Snapshot) Graph() (dot string) {
+	names := make([]string, 0, len(snap.Modules))
+	for name := range snap.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := &strings.Builder{}
+	b.WriteString("digraph components {\n")
+	for _, name := range names {
+		state := snap.Modules[name]
+		color := "green"
+		switch {
+		case state.Status == Degraded:
+			color = "orange"
+		case !state.Status.IsUp():
+			color = "red"
+		}
+		style := "solid"
+		if state.Class == Optional {
+			style = "dashed"
+		}
+		fmt.Fprintf(b, "  %q [label=%q color=%s style=%s];\n", name, name+"\n"+state.Status.String(), color, style)
+	}
+	for _, name := range names {
+		for _, dep := range snap.Modules[name].DependsOn {
+			fmt.Fprintf(b, "  %q -> %q;\n", name, dep)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+)
+
+// TestDependencies checks that dependencies propagate down states and that only critical
+// modules affect the aggregate state
+//
+func TestDependencies(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+
+	listener := make(chan bool, 1)
+	comps.AddListener(listener)
+
+	comps.SetClass("metrics", Optional)
+	if err := comps.DependsOn("runner", "queue", "storage"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err := comps.DependsOn("queue", "credentials"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err := comps.DependsOn("credentials", "runner"); err == nil {
+		t.Fatal("dependency cycle accepted", "stack", stack.Trace().TrimRuntime())
+	}
+	// A cycle later in the list leaves the earlier dependencies undeclared
+	if err := comps.DependsOn("credentials", "metrics", "queue"); err == nil {
+		t.Fatal("dependency cycle accepted", "stack", stack.Trace().TrimRuntime())
+	}
+
+	for _, module := range []string{"runner", "queue", "storage", "credentials"} {
+		comps.SetModule(module, true)
+	}
+	comps.SetStatus("metrics", Down, "exporter unreachable", nil)
+
+	// An optional module being down leaves the server up
+	waitListener(ctx, t, listener, true)
+	snap := comps.Snapshot()
+	if !snap.Up || len(snap.Down()) != 0 || snap.Modules["metrics"].Class != Optional {
+		t.Fatal("optional module affected the aggregate", snap, "stack", stack.Trace().TrimRuntime())
+	}
+	if deps := snap.Modules["credentials"].DependsOn; len(deps) != 0 {
+		t.Fatal("rejected dependencies declared", deps, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// A module is down when a dependency, direct or indirect, is down
+	comps.SetStatus("credentials", Down, "expired", nil)
+	waitListener(ctx, t, listener, false)
+
+	snap = comps.Snapshot()
+	queue := snap.Modules["queue"]
+	if queue.Status != Down || queue.Reason != "dependency down: credentials" || len(queue.BlockedBy) != 1 {
+		t.Fatal("dependency failure not propagated", queue, "stack", stack.Trace().TrimRuntime())
+	}
+	runner := snap.Modules["runner"]
+	if runner.Status != Down || strings.Join(runner.BlockedBy, ",") != "queue" || strings.Join(runner.DependsOn, ",") != "queue,storage" {
+		t.Fatal("indirect dependency failure not propagated", runner, "stack", stack.Trace().TrimRuntime())
+	}
+	if down := strings.Join(snap.Down(), ","); down != "credentials,queue,runner" {
+		t.Fatal("unexpected down modules", down, "stack", stack.Trace().TrimRuntime())
+	}
+
+	graph := snap.Graph()
+	for _, expected := range []string{
+		`"runner" -> "queue";`,
+		`"queue" -> "credentials";`,
+		`"credentials" [label="credentials\ndown" color=red style=solid];`,
+		`"metrics" [label="metrics\ndown" color=red style=dashed];`,
+		`"storage" [label="storage\nup" color=green style=solid];`,
+	} {
+		if !strings.Contains(graph, expected) {
+			t.Fatal("graph missing", expected, graph, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	comps.SetModule("credentials", true)
+	waitListener(ctx, t, listener, true)
+	if runner = comps.Snapshot().Modules["runner"]; runner.Status != Up || len(runner.BlockedBy) != 0 {
+		t.Fatal("module did not recover with its dependencies", runner, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Dependencies that have never reported block their dependents
+	if err := comps.DependsOn("storage", "disk"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if storage := comps.Snapshot().Modules["storage"]; storage.Status != Down || storage.BlockedBy[0] != "disk" {
+		t.Fatal("unreported dependency did not block", storage, "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package grpchealth // import "github.com/leaf-ai/go-service/pkg/components/grpchealth"
+
+// This file contains an implementation of the gRPC health checking protocol, grpc.health.v1,
+// that is backed by the modules tracked by a components.Components.  This allows Kubernetes gRPC
+// probes and service meshes to see the same state as the HTTP health handlers.
+//
+// The empty service name reports the aggregate state of the server.  Other service names are
+// reported using the module of the same name unless they have been mapped to a different module
+// using MapService.
+
+import (
+	"context"
+	"sync"
+
+	"github.com/leaf-ai/go-service/pkg/components"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the grpc.health.v1.Health service
+//
+type Server struct {
+	healthpb.UnimplementedHealthServer
+
+	comps    *components.Components
+	services map[string]string // Service names and the modules that report for them
+	sync.Mutex
+}
+
+// NewServer creates a health server reporting the state of the supplied modules
+//
+func NewServer(comps *components.Components) (server *Server) {
+	return &Server{
+		comps:    comps,
+		services: map[string]string{},
+	}
+}
+
+// Register creates a health server and registers it with a gRPC server
+//
+func Register(grpcServer *grpc.Server, comps *components.Components) (server *Server) {
+	server = NewServer(comps)
+	healthpb.RegisterHealthServer(grpcServer, server)
+	return server
+}
+
+// MapService causes a gRPC service name to be reported using the state of a module
+//
+func (server *Server) MapService(service string, module string) {
+	server.Lock()
+	defer server.Unlock()
+	server.services[service] = module
+}
+
+// moduleOf returns the module that reports for a service
+func (server *Server) moduleOf(service string) (module string) {
+	server.Lock()
+	defer server.Unlock()
+	if module, isPresent := server.services[service]; isPresent {
+		return module
+	}
+	return service
+}
+
+// servingStatus returns the current status of a service
+func (server *Server) servingStatus(service string) (serving healthpb.HealthCheckResponse_ServingStatus) {
+	snap := server.comps.Snapshot()
+
+	if len(service) == 0 {
+		if snap.Up {
+			return healthpb.HealthCheckResponse_SERVING
+		}
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	state, isPresent := snap.Modules[server.moduleOf(service)]
+	switch {
+	case !isPresent:
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	case state.Status == components.Unknown:
+		return healthpb.HealthCheckResponse_UNKNOWN
+	case state.Status.IsUp():
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// Check returns the current status of a service, services with no module are reported using
+// the NotFound code as required by the protocol
+//
+func (server *Server) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (resp *healthpb.HealthCheckResponse, errGo error) {
+	serving := server.servingStatus(req.GetService())
+	if serving == healthpb.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: serving}, nil
+}
+
+// Watch sends the status of a service immediately and then each time that it changes, until
+// the client cancels the stream
+//
+func (server *Server) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) (errGo error) {
+	service := req.GetService()
+
+	// Module changes are seen using a subscription, while the listener catches changes to the
+	// aggregate that are not caused by a module changing, such as those delayed by hysteresis
+	sub := server.comps.Subscribe(components.SubscribeOpts{Buffer: 1, Policy: components.DropOldest})
+	defer sub.Unsubscribe()
+
+	listener := make(chan bool, 1)
+	server.comps.AddListener(listener)
+	defer server.comps.RemoveListener(listener)
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if serving := server.servingStatus(service); serving != last {
+			if errGo = stream.Send(&healthpb.HealthCheckResponse{Status: serving}); errGo != nil {
+				return status.Error(codes.Canceled, errGo.Error())
+			}
+			last = serving
+		}
+
+		select {
+		case <-sub.C:
+		case <-listener:
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "stream has ended")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package grpchealth
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/leaf-ai/go-service/pkg/components"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer serves the health service over an in-process connection and returns a client
+func startServer(ctx context.Context, t *testing.T, comps *components.Components) (server *Server, client healthpb.HealthClient) {
+	listener := bufconn.Listen(1024 * 1024)
+
+	grpcServer := grpc.NewServer()
+	server = Register(grpcServer, comps)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, errGo := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return server, healthpb.NewHealthClient(conn)
+}
+
+func check(ctx context.Context, t *testing.T, client healthpb.HealthClient, service string) (serving healthpb.HealthCheckResponse_ServingStatus) {
+	resp, errGo := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	return resp.GetStatus()
+}
+
+// TestCheck checks that services are reported using their modules and the aggregate state
+//
+func TestCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := components.InitComponentTracking(ctx)
+	server, client := startServer(ctx, t, comps)
+	server.MapService("runner.v1.Runner", "queue")
+
+	comps.SetModule("queue", true)
+	comps.SetModule("storage", true)
+
+	if serving := check(ctx, t, client, ""); serving != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("unexpected aggregate status", serving, "stack", stack.Trace().TrimRuntime())
+	}
+	if serving := check(ctx, t, client, "runner.v1.Runner"); serving != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("unexpected mapped status", serving, "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps.SetStatus("storage", components.Down, "bucket missing", nil)
+	if serving := check(ctx, t, client, "storage"); serving != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatal("unexpected module status", serving, "stack", stack.Trace().TrimRuntime())
+	}
+	if serving := check(ctx, t, client, ""); serving != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatal("unexpected aggregate status", serving, "stack", stack.Trace().TrimRuntime())
+	}
+
+	_, errGo := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"})
+	if status.Code(errGo) != codes.NotFound {
+		t.Fatal("unknown service not rejected", errGo, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestWatch checks that watchers receive the initial status and subsequent changes
+//
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := components.InitComponentTracking(ctx)
+	_, client := startServer(ctx, t, comps)
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+
+	stream, errGo := client.Watch(watchCtx, &healthpb.HealthCheckRequest{Service: "queue"})
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	for _, expected := range []struct {
+		update  func()
+		serving healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{func() {}, healthpb.HealthCheckResponse_SERVICE_UNKNOWN},
+		{func() { comps.SetModule("queue", true) }, healthpb.HealthCheckResponse_SERVING},
+		{func() { comps.SetModule("queue", false) }, healthpb.HealthCheckResponse_NOT_SERVING},
+	} {
+		expected.update()
+		resp, errGo := stream.Recv()
+		if errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		if resp.GetStatus() != expected.serving {
+			t.Fatal("unexpected watched status", resp.GetStatus(), expected.serving, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	stopWatch()
+	if _, errGo = stream.Recv(); status.Code(errGo) != codes.Canceled {
+		t.Fatal("watch not cancelled", errGo, "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains the implementation of heartbeats, used as a watchdog for long running loops.
+// A module with a heartbeat is up while it continues to beat, and is marked down should a beat
+// not arrive within its time to live.
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// ReasonHeartbeatMissed is the reason recorded for modules whose heartbeat has expired
+	ReasonHeartbeatMissed = "heartbeat missed"
+)
+
+// Heartbeat is a watchdog for a single module, the module must call Beat within every TTL
+//
+type Heartbeat struct {
+	Module string
+	TTL    time.Duration
+
+	comps    *Components
+	timer    *time.Timer
+	lastBeat time.Time
+	up       bool
+	stopC    chan struct{}
+	stopped  bool
+	sync.Mutex
+}
+
+// AddHeartbeat starts a watchdog for a module.  The module is Unknown until its first beat,
+// and is marked down if that, or any later beat, does not arrive within the TTL.  The
+// watchdog runs until it is stopped, or the tracking context is Done().
+//
+// Beat can be passed directly to loops that accept a beat function, for example the Beat
+// member of queue.ProcessOpts.
+//
+func (comps *Components) AddHeartbeat(module string, ttl time.Duration) (hb *Heartbeat) {
+	hb = &Heartbeat{
+		Module:   module,
+		TTL:      ttl,
+		comps:    comps,
+		lastBeat: time.Now(),
+		stopC:    make(chan struct{}),
+	}
+
+	comps.SetStatus(module, Unknown, "awaiting heartbeat", nil)
+
+	hb.Lock()
+	hb.timer = time.AfterFunc(ttl, hb.expired)
+	hb.Unlock()
+
+	go func() {
+		select {
+		case <-comps.ctx.Done():
+			hb.Stop()
+		case <-hb.stopC:
+		}
+	}()
+
+	return hb
+}
+
+// Beat records that the module is alive
+//
+func (hb *Heartbeat) Beat() {
+	hb.Lock()
+	defer hb.Unlock()
+
+	if hb.stopped {
+		return
+	}
+	hb.lastBeat = time.Now()
+	hb.timer.Reset(hb.TTL)
+
+	// Only transitions are passed to the tracker to keep beats cheap
+	if !hb.up {
+		hb.up = true
+		hb.comps.SetStatus(hb.Module, Up, "", nil)
+	}
+}
+
+// Stop ends the watchdog leaving the module in its current state
+//
+func (hb *Heartbeat) Stop() {
+	hb.Lock()
+	defer hb.Unlock()
+
+	if hb.stopped {
+		return
+	}
+	hb.stopped = true
+	hb.timer.Stop()
+	close(hb.stopC)
+}
+
+// expired is called by the timer when no beat has arrived within the TTL
+func (hb *Heartbeat) expired() {
+	hb.Lock()
+	defer hb.Unlock()
+
+	// A beat may have arrived while the timer was firing
+	if hb.stopped || time.Since(hb.lastBeat) < hb.TTL {
+		return
+	}
+	hb.up = false
+	hb.comps.SetStatus(hb.Module, Down, ReasonHeartbeatMissed, map[string]string{
+		"ttl":       hb.TTL.String(),
+		"last_beat": hb.lastBeat.UTC().Format(time.RFC3339Nano),
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+)
+
+// TestHeartbeat checks that a module is marked down when its heartbeat stops and recovers
+// when beats resume
+//
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+
+	listener := make(chan bool, 1)
+	comps.AddListener(listener)
+
+	hb := comps.AddHeartbeat("poller", 50*time.Millisecond)
+	if state := comps.Snapshot().Modules["poller"]; state.Status != Unknown {
+		t.Fatal("unexpected initial state", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Beating faster than the TTL keeps the module up
+	for i := 0; i != 10; i++ {
+		hb.Beat()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := comps.Snapshot().Modules["poller"]; state.Status != Up {
+		t.Fatal("beating module not up", state, "stack", stack.Trace().TrimRuntime())
+	}
+	waitListener(ctx, t, listener, true)
+
+	// A stalled loop is detected and reported to listeners
+	state := waitStatus(ctx, t, comps, "poller", Down)
+	if state.Reason != ReasonHeartbeatMissed || state.Details["ttl"] != "50ms" {
+		t.Fatal("unexpected state", state, "stack", stack.Trace().TrimRuntime())
+	}
+	waitListener(ctx, t, listener, false)
+
+	hb.Beat()
+	waitStatus(ctx, t, comps, "poller", Up)
+
+	// Once stopped the module keeps its state
+	hb.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if state = comps.Snapshot().Modules["poller"]; state.Status != Up {
+		t.Fatal("stopped heartbeat changed state", state, "stack", stack.Trace().TrimRuntime())
+	}
+	hb.Beat()
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains the history of module transitions.  Only the current state of modules is
+// needed to decide if the server is up, however after a brief outage it is the sequence of
+// changes that explains what happened.  A bounded number of transitions is retained for each
+// module and can be retrieved, served over HTTP, and optionally logged as they happen.
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/leaf-ai/go-service/pkg/log"
+)
+
+// DefaultHistorySize is the number of transitions retained for each module unless changed
+// using SetHistorySize
+const DefaultHistorySize = 32
+
+// Transition records a change in the status of a module
+//
+type Transition struct {
+	Time   time.Time `json:"time"`
+	Module string    `json:"module"`
+	Old    Status    `json:"old"`
+	New    Status    `json:"new"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// ring is a fixed size buffer of transitions that overwrites the oldest when full
+type ring struct {
+	entries []Transition
+	next    int
+	count   int
+}
+
+func newRing(size int) (r *ring) {
+	return &ring{entries: make([]Transition, size)}
+}
+
+func (r *ring) add(transition Transition) {
+	r.entries[r.next] = transition
+	r.next = (r.next + 1) % len(r.entries)
+	if r.count < len(r.entries) {
+		r.count++
+	}
+}
+
+// list returns the retained transitions, oldest first
+func (r *ring) list() (transitions []Transition) {
+	transitions = make([]Transition, 0, r.count)
+	start := r.next - r.count + len(r.entries)
+	for i := 0; i != r.count; i++ {
+		transitions = append(transitions, r.entries[(start+i)%len(r.entries)])
+	}
+	return transitions
+}
+
+// SetHistorySize changes the number of transitions retained for each module, the most recent
+// transitions are kept when the history is shrunk
+//
+func (comps *Components) SetHistorySize(size int) {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+
+	comps.Lock()
+	defer comps.Unlock()
+
+	comps.historySize = size
+	for module, old := range comps.history {
+		resized := newRing(size)
+		for _, transition := range old.list() {
+			resized.add(transition)
+		}
+		comps.history[module] = resized
+	}
+}
+
+// SetTransitionLogger causes transitions to be logged as they are recorded, transitions to a
+// status that is not up are logged as warnings and all others as information.  Supplying a nil
+// logger stops logging.
+//
+func (comps *Components) SetTransitionLogger(logger *log.Logger) {
+	comps.Lock()
+	defer comps.Unlock()
+	comps.logger = logger
+}
+
+// History returns the retained transitions for a module, oldest first
+//
+func (comps *Components) History(module string) (transitions []Transition) {
+	comps.Lock()
+	defer comps.Unlock()
+
+	if r, isPresent := comps.history[module]; isPresent {
+		return r.list()
+	}
+	return []Transition{}
+}
+
+// Histories returns the retained transitions for every module
+//
+func (comps *Components) Histories() (histories map[string][]Transition) {
+	comps.Lock()
+	defer comps.Unlock()
+
+	histories = make(map[string][]Transition, len(comps.history))
+	for module, r := range comps.history {
+		histories[module] = r.list()
+	}
+	return histories
+}
+
+// HistoryHandler returns a handler serving the retained transitions as JSON, the module query
+// parameter can be used to select a single module
+//
+func (comps *Components) HistoryHandler() (handler http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		histories := map[string][]Transition{}
+		if module := r.URL.Query().Get("module"); len(module) != 0 {
+			histories[module] = comps.History(module)
+		} else {
+			histories = comps.Histories()
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(histories)
+	}
+}
+
+// addTransition retains a transition and queues it to be logged when a logger has been
+// supplied, the caller is expected to be holding the lock and to call logTransitions once it
+// has been released
+func (comps *Components) addTransition(transition Transition) {
+	r, isPresent := comps.history[transition.Module]
+	if !isPresent {
+		if comps.historySize <= 0 {
+			comps.historySize = DefaultHistorySize
+		}
+		r = newRing(comps.historySize)
+		comps.history[transition.Module] = r
+	}
+	r.add(transition)
+
+	if comps.logger != nil {
+		comps.unlogged = append(comps.unlogged, transition)
+	}
+}
+
+// logTransitions logs the transitions queued by addTransition.  Loggers can block, for example
+// when asynchronous with a full queue, so this is called without holding the lock to avoid
+// stalling every user of the components.  Only one caller logs at a time, it continues until
+// the queue is empty while other callers return immediately.
+func (comps *Components) logTransitions() {
+	comps.Lock()
+	defer comps.Unlock()
+
+	if comps.logging {
+		return
+	}
+	comps.logging = true
+	defer func() { comps.logging = false }()
+
+	for len(comps.unlogged) != 0 {
+		transitions, logger := comps.unlogged, comps.logger
+		comps.unlogged = nil
+
+		comps.Unlock()
+		writeTransitions(logger, transitions)
+		comps.Lock()
+	}
+}
+
+// writeTransitions outputs transitions using a logger
+func writeTransitions(logger *log.Logger, transitions []Transition) {
+	if logger == nil {
+		return
+	}
+	for _, transition := range transitions {
+		args := []interface{}{
+			"module", transition.Module,
+			"old", transition.Old.String(),
+			"new", transition.New.String(),
+		}
+		if len(transition.Reason) != 0 {
+			args = append(args, "reason", transition.Reason)
+		}
+		if transition.New.IsUp() {
+			logger.Info("module state changed", args...)
+			continue
+		}
+		_ = logger.Warn("module state changed", args...)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/leaf-ai/go-service/pkg/log"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// entrySink retains the entries logged to it
+type entrySink struct {
+	entries []*log.Entry
+	sync.Mutex
+}
+
+func (sink *entrySink) Write(entry *log.Entry) {
+	sink.Lock()
+	defer sink.Unlock()
+	sink.entries = append(sink.entries, entry)
+}
+
+// TestHistory checks that a bounded history of transitions is retained, served and logged
+//
+func TestHistory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+	comps.SetHistorySize(3)
+
+	logger := log.NewLogger("components")
+	logger.SetLevel(logxi.LevelInfo)
+	sink := &entrySink{}
+	logger.AddSink(sink)
+	comps.SetTransitionLogger(logger)
+
+	comps.SetModule("queue", true)
+	comps.SetStatus("queue", Down, "connection refused", nil)
+	// Reports that do not change the status are not transitions
+	comps.SetStatus("queue", Down, "connection reset", nil)
+	comps.SetModule("queue", true)
+	comps.SetStatus("queue", Degraded, "slow", nil)
+
+	history := comps.History("queue")
+	if len(history) != 3 {
+		t.Fatal("unexpected history length", history, "stack", stack.Trace().TrimRuntime())
+	}
+	if first := history[0]; first.Old != Up || first.New != Down || first.Reason != "connection refused" {
+		t.Fatal("unexpected oldest transition", first, "stack", stack.Trace().TrimRuntime())
+	}
+	if last := history[2]; last.Old != Up || last.New != Degraded || last.Module != "queue" {
+		t.Fatal("unexpected newest transition", last, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Shrinking the history keeps the most recent transitions
+	comps.SetHistorySize(1)
+	if history = comps.History("queue"); len(history) != 1 || history[0].New != Degraded {
+		t.Fatal("history not resized", history, "stack", stack.Trace().TrimRuntime())
+	}
+
+	server := httptest.NewServer(comps.HealthHandler())
+	defer server.Close()
+
+	status, body := probe(t, server, "/history?module=queue", "")
+	histories := map[string][]Transition{}
+	if errGo := json.Unmarshal([]byte(body), &histories); errGo != nil {
+		t.Fatal(errGo.Error(), body, "stack", stack.Trace().TrimRuntime())
+	}
+	if status != http.StatusOK || len(histories["queue"]) != 1 || histories["queue"][0].Reason != "slow" {
+		t.Fatal("unexpected history response", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+
+	sink.Lock()
+	defer sink.Unlock()
+	if len(sink.entries) != 4 {
+		t.Fatal("unexpected transitions logged", len(sink.entries), "stack", stack.Trace().TrimRuntime())
+	}
+	fields := sink.entries[1].Fields()
+	if sink.entries[1].Level != logxi.LevelWarn || fields["module"] != "queue" || fields["new"] != "down" || fields["reason"] != "connection refused" {
+		t.Fatal("unexpected transition logged", fields, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// gatedSink holds every entry written to it until it is released
+type gatedSink struct {
+	startedC chan struct{}
+	releaseC chan struct{}
+}
+
+func (sink *gatedSink) Write(entry *log.Entry) {
+	select {
+	case sink.startedC <- struct{}{}:
+	default:
+	}
+	<-sink.releaseC
+}
+
+// TestHistoryBlockedLogger checks that a logger that blocks does not stall other users of the
+// components while transitions are being logged
+//
+func TestHistoryBlockedLogger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+
+	logger := log.NewLogger("components-blocked")
+	logger.SetLevel(logxi.LevelInfo)
+	sink := &gatedSink{startedC: make(chan struct{}, 1), releaseC: make(chan struct{})}
+	logger.AddSink(sink)
+	comps.SetTransitionLogger(logger)
+
+	go comps.SetModule("queue", true)
+	select {
+	case <-sink.startedC:
+	case <-ctx.Done():
+		t.Fatal("transition not logged", "stack", stack.Trace().TrimRuntime())
+	}
+
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		comps.SetModule("database", true)
+		comps.Snapshot()
+	}()
+	select {
+	case <-doneC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("components stalled by the logger", "stack", stack.Trace().TrimRuntime())
+	}
+	close(sink.releaseC)
+}
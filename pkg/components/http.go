@@ -0,0 +1,159 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains HTTP handlers that expose the state of the modules using the Kubernetes
+// health endpoint conventions, /livez and /readyz, along with the older /healthz.  A 200 status
+// is returned when the modules examined by an endpoint are all up, otherwise a 503 is returned.
+//
+// Optional modules are listed but do not cause a check to fail.
+//
+// Responses are plain text unless JSON is requested using an Accept header, or the format=json
+// query parameter.  Plain text responses list every module when the verbose query parameter is
+// present, or when the check fails.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// probeResult is the JSON rendering of a health endpoint response
+type probeResult struct {
+	Probe   string                 `json:"probe"`
+	Up      bool                   `json:"up"`
+	Taken   time.Time              `json:"taken"`
+	Modules map[string]ModuleState `json:"modules"`
+}
+
+// HealthHandler returns a handler serving /livez, /readyz and /healthz, along with the
+// module transition history on /history
+//
+func (comps *Components) HealthHandler() (handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/livez", comps.LivezHandler())
+	mux.Handle("/readyz", comps.ReadyzHandler())
+	mux.Handle("/healthz", comps.HealthzHandler())
+	mux.Handle("/history", comps.HistoryHandler())
+	return mux
+}
+
+// LivezHandler returns a handler that reports on the modules marked using SetLiveness.  The
+// status each module reports itself is used, modules that are only blocked by a dependency
+// do not fail the probe as restarting the server would not help them.
+//
+func (comps *Components) LivezHandler() (handler http.HandlerFunc) {
+	return comps.probeHandler("livez", true)
+}
+
+// ReadyzHandler returns a handler that reports on all modules
+//
+func (comps *Components) ReadyzHandler() (handler http.HandlerFunc) {
+	return comps.probeHandler("readyz", false)
+}
+
+// HealthzHandler returns a handler that reports on all modules, it is provided for probes
+// that predate the split between liveness and readiness
+//
+func (comps *Components) HealthzHandler() (handler http.HandlerFunc) {
+	return comps.probeHandler("healthz", false)
+}
+
+func wantsJSON(r *http.Request) bool {
+	if strings.EqualFold(r.URL.Query().Get("format"), "json") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// probedStatus returns the status of a module used by a probe.  Liveness uses the status
+// reported by the module itself, a module that is only blocked by its dependencies does not
+// need to be restarted.
+func probedStatus(state ModuleState, livenessOnly bool) (status Status) {
+	if livenessOnly {
+		return state.Reported
+	}
+	return state.Status
+}
+
+func (comps *Components) probeHandler(probe string, livenessOnly bool) (handler http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := comps.Snapshot()
+
+		result := &probeResult{
+			Probe:   probe,
+			Up:      true,
+			Taken:   snap.Taken,
+			Modules: make(map[string]ModuleState, len(snap.Modules)),
+		}
+		for name, state := range snap.Modules {
+			if livenessOnly && !state.Liveness {
+				continue
+			}
+			result.Modules[name] = state
+			if state.Class == Critical && !probedStatus(state, livenessOnly).IsUp() {
+				result.Up = false
+			}
+		}
+		// Readiness uses the aggregate state so that it is subject to any hysteresis
+		if !livenessOnly {
+			result.Up = snap.Up
+		}
+
+		status := http.StatusOK
+		if !result.Up {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+
+		_, verbose := r.URL.Query()["verbose"]
+		if result.Up && !verbose {
+			fmt.Fprint(w, "ok")
+			return
+		}
+
+		names := make([]string, 0, len(result.Modules))
+		for name := range result.Modules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			state := result.Modules[name]
+			status := probedStatus(state, livenessOnly)
+			mark := "+"
+			if !status.IsUp() {
+				mark = "-"
+			}
+			line := fmt.Sprintf("[%s]%s %s", mark, name, status)
+			if state.Class == Optional {
+				line += " (optional)"
+			}
+			// The reason of a blocked module describes its dependencies, which liveness ignores
+			if len(state.Reason) != 0 && (!livenessOnly || len(state.BlockedBy) == 0) {
+				line += ": " + state.Reason
+			}
+			fmt.Fprintln(w, line)
+		}
+		if result.Up {
+			fmt.Fprintf(w, "%s check passed\n", probe)
+		} else {
+			fmt.Fprintf(w, "%s check failed\n", probe)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+)
+
+func probe(t *testing.T, server *httptest.Server, path string, accept string) (status int, body string) {
+	req, errGo := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if len(accept) != 0 {
+		req.Header.Set("Accept", accept)
+	}
+	resp, errGo := http.DefaultClient.Do(req)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer resp.Body.Close()
+	data, errGo := ioutil.ReadAll(resp.Body)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	return resp.StatusCode, string(data)
+}
+
+// TestHealthHandler checks the probe endpoints distinguish liveness from readiness
+//
+func TestHealthHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+	comps.SetLiveness("runtime", true)
+	comps.SetModule("runtime", true)
+	comps.SetStatus("queue", Degraded, "slow receives", nil)
+
+	server := httptest.NewServer(comps.HealthHandler())
+	defer server.Close()
+
+	for _, path := range []string{"/livez", "/readyz", "/healthz"} {
+		if status, body := probe(t, server, path, ""); status != http.StatusOK || body != "ok" {
+			t.Fatal("unexpected response", path, status, body, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	status, body := probe(t, server, "/readyz?verbose", "")
+	if status != http.StatusOK || !strings.Contains(body, "[+]queue degraded: slow receives\n") ||
+		!strings.Contains(body, "[+]runtime up\n") || !strings.HasSuffix(body, "readyz check passed\n") {
+		t.Fatal("unexpected verbose response", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// A failed readiness module leaves the server alive
+	comps.SetStatus("queue", Down, "connection refused", nil)
+
+	if status, body = probe(t, server, "/livez", ""); status != http.StatusOK {
+		t.Fatal("liveness failed on a readiness module", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+	status, body = probe(t, server, "/readyz", "")
+	if status != http.StatusServiceUnavailable || !strings.Contains(body, "[-]queue down: connection refused\n") ||
+		!strings.HasSuffix(body, "readyz check failed\n") {
+		t.Fatal("unexpected readiness response", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps.SetStatus("runtime", Down, "deadlocked", nil)
+	status, body = probe(t, server, "/livez", "application/json")
+	if status != http.StatusServiceUnavailable {
+		t.Fatal("liveness passed with a failed liveness module", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+	result := &probeResult{}
+	if errGo := json.Unmarshal([]byte(body), result); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if result.Up || result.Probe != "livez" || len(result.Modules) != 1 || result.Modules["runtime"].Reason != "deadlocked" {
+		t.Fatal("unexpected JSON response", body, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestLivezDependency checks that a liveness module blocked only by a dependency does not
+// fail the liveness probe, while readiness reports it as down
+//
+func TestLivezDependency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+	comps.SetLiveness("runtime", true)
+	if err := comps.DependsOn("runtime", "database"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	comps.SetModule("runtime", true)
+	comps.SetStatus("database", Down, "connection refused", nil)
+
+	server := httptest.NewServer(comps.HealthHandler())
+	defer server.Close()
+
+	if status, body := probe(t, server, "/livez?verbose", ""); status != http.StatusOK || !strings.Contains(body, "[+]runtime up\n") {
+		t.Fatal("liveness failed on a blocked module", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+	if status, body := probe(t, server, "/readyz?verbose", ""); status != http.StatusServiceUnavailable || !strings.Contains(body, "[-]runtime down: dependency down: database\n") {
+		t.Fatal("readiness passed with a blocked module", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+
+	state := comps.Snapshot().Modules["runtime"]
+	if state.Status != Down || state.Reported != Up {
+		t.Fatal("unexpected module state", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Failures of the module itself are still seen
+	comps.SetStatus("runtime", Down, "deadlocked", nil)
+	if status, body := probe(t, server, "/livez", ""); status != http.StatusServiceUnavailable {
+		t.Fatal("liveness passed with a failed liveness module", status, body, "stack", stack.Trace().TrimRuntime())
+	}
+}
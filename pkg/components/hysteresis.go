@@ -0,0 +1,214 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains the damping applied to module reports before they are recorded.  Modules
+// that depend on networks can bounce between up and down, without damping every bounce would
+// be seen by readiness probes and cause load balancers to churn.
+//
+// Three mechanisms are offered, all disabled by default.  The aggregate state can be required to
+// hold for a minimum time before listeners and probes see it change, modules that are down can
+// be required to report up a number of times in a row before they are recorded as up, and modules
+// that change state too often within a window are held down and marked as flapping.
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReasonFlapping is the reason recorded for modules held down by the flap detector
+const ReasonFlapping = "flapping"
+
+// HysteresisOpts contains the parameters used to damp changes in module and aggregate state
+//
+type HysteresisOpts struct {
+	// MinInState is how long the aggregate state must hold before it is reported as changed
+	MinInState time.Duration
+	// RecoverAfter is the number of consecutive up reports needed before a down module is
+	// recorded as being up
+	RecoverAfter int
+	// FlapWindow is the period over which changes between up and down are counted
+	FlapWindow time.Duration
+	// FlapLimit is the number of changes within FlapWindow at which a module is considered to be
+	// flapping, zero disables flap detection
+	FlapLimit int
+	// HoldDown is how long a flapping module is held down after its last change, defaults to
+	// FlapWindow
+	HoldDown time.Duration
+}
+
+// damping holds what a module has reported, prior to hysteresis being applied
+type damping struct {
+	reported    Status
+	reason      string
+	details     map[string]string
+	successes   int         // Consecutive up reports
+	transitions []time.Time // Changes between up and down within the flap window
+	heldUntil   time.Time
+	timer       *time.Timer
+}
+
+// aggregate holds the aggregate state reported to listeners along with any pending change
+type aggregate struct {
+	up           bool
+	isSet        bool
+	pendingSince time.Time
+}
+
+// SetHysteresis configures the damping applied to module reports and to the aggregate state,
+// it takes effect from the next report made by each module
+//
+func (comps *Components) SetHysteresis(opts HysteresisOpts) {
+	comps.Lock()
+	defer comps.Unlock()
+	comps.hysteresis = opts
+	comps.changed()
+}
+
+// IsFlapping tests if a module is being held down for changing state too frequently
+//
+func (comps *Components) IsFlapping(module string) (flapping bool) {
+	comps.Lock()
+	defer comps.Unlock()
+	if d, isPresent := comps.damping[module]; isPresent {
+		return time.Now().Before(d.heldUntil)
+	}
+	return false
+}
+
+// dampingOf returns the reported state for a module, creating it if needed, the caller is
+// expected to be holding the lock
+func (comps *Components) dampingOf(module string) (d *damping) {
+	d, isPresent := comps.damping[module]
+	if !isPresent {
+		d = &damping{}
+		comps.damping[module] = d
+	}
+	return d
+}
+
+// record applies hysteresis to the last report made by a module and stores the result, the
+// caller is expected to be holding the lock
+func (comps *Components) record(module string, now time.Time) {
+	d := comps.dampingOf(module)
+	opts := comps.hysteresis
+
+	status := d.reported
+	reason := d.reason
+
+	if opts.FlapLimit > 0 {
+		cutoff := now.Add(-opts.FlapWindow)
+		kept := d.transitions[:0]
+		for _, when := range d.transitions {
+			if when.After(cutoff) {
+				kept = append(kept, when)
+			}
+		}
+		d.transitions = kept
+
+		if len(d.transitions) >= opts.FlapLimit {
+			hold := opts.HoldDown
+			if hold <= 0 {
+				hold = opts.FlapWindow
+			}
+			d.heldUntil = d.transitions[len(d.transitions)-1].Add(hold)
+		}
+	}
+
+	state, isPresent := comps.components[module]
+
+	flapping := now.Before(d.heldUntil)
+	switch {
+	case flapping:
+		status = Down
+		reason = ReasonFlapping
+		comps.recheck(module, d, d.heldUntil.Sub(now))
+	case isPresent && state.Status == Down && status.IsUp() && d.successes < opts.RecoverAfter:
+		status = Down
+		reason = fmt.Sprintf("recovering, %d of %d successes", d.successes, opts.RecoverAfter)
+	}
+
+	if !isPresent {
+		state = &ModuleState{Since: now}
+		comps.components[module] = state
+	}
+	if !isPresent || state.Status != status {
+		comps.addTransition(Transition{
+			Time:   now,
+			Module: module,
+			Old:    state.Status,
+			New:    status,
+			Reason: reason,
+		})
+		state.Since = now
+	}
+	state.Status = status
+	state.Reason = reason
+	state.Updated = now
+	state.Details = d.details
+	state.Flapping = flapping
+}
+
+// recheck arranges for a module held down by the flap detector to be recorded again once the
+// hold expires, the caller is expected to be holding the lock
+func (comps *Components) recheck(module string, d *damping, after time.Duration) {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(after, func() {
+		defer comps.logTransitions()
+
+		comps.Lock()
+		defer comps.Unlock()
+		comps.record(module, time.Now())
+		comps.changed()
+	})
+}
+
+// reportedUp returns the aggregate state of resolved modules with the minimum time in state
+// applied, without changing any state so that it can be used by readers such as Snapshot.
+// The caller is expected to be holding the lock.
+func (comps *Components) reportedUp(modules map[string]ModuleState) (up bool) {
+	up = isUp(modules)
+	agg := comps.aggregate
+	minInState := comps.hysteresis.MinInState
+
+	if !agg.isSet || minInState <= 0 || up == agg.up {
+		return up
+	}
+	// A pending change that has held long enough is reported ahead of the update loop
+	// committing it
+	if !agg.pendingSince.IsZero() && time.Since(agg.pendingSince) >= minInState {
+		return up
+	}
+	return agg.up
+}
+
+// advanceAggregate evaluates the aggregate state of resolved modules with the minimum time in
+// state applied, starting or committing pending changes.  It is used by the update loop and
+// when modules change, the caller is expected to be holding the lock.
+func (comps *Components) advanceAggregate(modules map[string]ModuleState) (up bool) {
+	up = isUp(modules)
+	agg := &comps.aggregate
+	minInState := comps.hysteresis.MinInState
+
+	if !agg.isSet || minInState <= 0 || up == agg.up {
+		agg.up = up
+		agg.isSet = true
+		agg.pendingSince = time.Time{}
+		return up
+	}
+
+	now := time.Now()
+	if agg.pendingSince.IsZero() {
+		agg.pendingSince = now
+		// Wake the update loop once the change has held for long enough
+		time.AfterFunc(minInState, comps.signal)
+	}
+	if now.Sub(agg.pendingSince) < minInState {
+		return agg.up
+	}
+	agg.up = up
+	agg.pendingSince = time.Time{}
+	return up
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+)
+
+// TestFlapping checks that modules changing state too often are held down and marked as
+// flapping until the hold expires
+//
+func TestFlapping(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+	comps.SetHysteresis(HysteresisOpts{
+		FlapWindow: time.Minute,
+		FlapLimit:  4,
+		HoldDown:   200 * time.Millisecond,
+	})
+
+	comps.SetModule("network", true)
+	for i := 0; i != 2; i++ {
+		comps.SetModule("network", false)
+		comps.SetModule("network", true)
+	}
+
+	if !comps.IsFlapping("network") {
+		t.Fatal("module not detected as flapping", "stack", stack.Trace().TrimRuntime())
+	}
+	state := comps.Snapshot().Modules["network"]
+	if state.Status != Down || state.Reason != ReasonFlapping || !state.Flapping {
+		t.Fatal("flapping module not held down", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Once the hold expires the last report is used
+	state = waitStatus(ctx, t, comps, "network", Up)
+	if state.Flapping || comps.IsFlapping("network") || comps.IsFlapping("unknown") {
+		t.Fatal("flapping marker not cleared", state, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestRecoverAfter checks that down modules need consecutive up reports to be recorded as up
+//
+func TestRecoverAfter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+	comps.SetHysteresis(HysteresisOpts{RecoverAfter: 3})
+
+	// Modules that have not been down are not damped
+	comps.SetModule("queue", true)
+	if state := comps.Snapshot().Modules["queue"]; state.Status != Up {
+		t.Fatal("new module was damped", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps.SetModule("queue", false)
+	comps.SetModule("queue", true)
+	comps.SetModule("queue", false)
+	comps.SetModule("queue", true)
+	comps.SetModule("queue", true)
+
+	state := comps.Snapshot().Modules["queue"]
+	if state.Status != Down || !strings.HasPrefix(state.Reason, "recovering, 2 of 3") {
+		t.Fatal("module recovered early", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps.SetModule("queue", true)
+	if state = comps.Snapshot().Modules["queue"]; state.Status != Up || len(state.Reason) != 0 {
+		t.Fatal("module did not recover", state, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestMinInState checks that changes to the aggregate state are only reported once they have
+// held for the minimum time
+//
+func TestMinInState(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	minInState := 300 * time.Millisecond
+
+	comps := InitComponentTracking(ctx)
+	comps.SetHysteresis(HysteresisOpts{MinInState: minInState})
+
+	listener := make(chan bool, 1)
+	comps.AddListener(listener)
+
+	comps.SetModule("network", true)
+	waitListener(ctx, t, listener, true)
+
+	// Reading the aggregate does not start a pending change, only the update loop and module
+	// reports do
+	comps.Lock()
+	before := comps.aggregate
+	comps.components["network"].Status = Down
+	comps.Unlock()
+	if !comps.Snapshot().Up {
+		t.Fatal("aggregate changed without damping", "stack", stack.Trace().TrimRuntime())
+	}
+	comps.Lock()
+	after := comps.aggregate
+	comps.components["network"].Status = Up
+	comps.Unlock()
+	if after != before {
+		t.Fatal("snapshot changed the aggregate state", before, after, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// A short outage is not seen in the aggregate
+	comps.SetModule("network", false)
+	if snap := comps.Snapshot(); !snap.Up || snap.Modules["network"].Status != Down {
+		t.Fatal("aggregate changed without damping", snap, "stack", stack.Trace().TrimRuntime())
+	}
+	comps.SetModule("network", true)
+
+	// A sustained outage is seen once it has lasted long enough
+	started := time.Now()
+	comps.SetModule("network", false)
+	waitListener(ctx, t, listener, false)
+	if elapsed := time.Since(started); elapsed < minInState {
+		t.Fatal("aggregate changed early", elapsed, "stack", stack.Trace().TrimRuntime())
+	}
+	if comps.Snapshot().Up {
+		t.Fatal("snapshot disagrees with listener", "stack", stack.Trace().TrimRuntime())
+	}
+}
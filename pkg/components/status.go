@@ -0,0 +1,138 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains the definitions of the health status recorded for each module, and
+// the snapshot used to report the state of all modules at a point in time
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// Status is the health of a single module
+//
+type Status int
+
+const (
+	// Unknown is used for modules that have not yet reported, or have stopped reporting
+	Unknown Status = iota
+	// Up indicates a module is fully functional
+	Up
+	// Degraded indicates a module is functional but impaired, it is counted as up
+	Degraded
+	// Down indicates a module is not functional
+	Down
+)
+
+var statusNames = map[Status]string{
+	Unknown:  "unknown",
+	Up:       "up",
+	Degraded: "degraded",
+	Down:     "down",
+}
+
+// String returns the lower case name of the status
+//
+func (s Status) String() string {
+	if name, isPresent := statusNames[s]; isPresent {
+		return name
+	}
+	return statusNames[Unknown]
+}
+
+// IsUp returns true for the statuses that are counted as the module being available
+//
+func (s Status) IsUp() bool {
+	return s == Up || s == Degraded
+}
+
+// MarshalText encodes the status using its name
+//
+func (s Status) MarshalText() (text []byte, errGo error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a status name
+//
+func (s *Status) UnmarshalText(text []byte) (errGo error) {
+	for status, name := range statusNames {
+		if strings.EqualFold(name, string(text)) {
+			*s = status
+			return nil
+		}
+	}
+	return kv.NewError("unknown status").With("status", string(text)).With("stack", stack.Trace().TrimRuntime())
+}
+
+// ModuleState is the health recorded for a single module
+//
+type ModuleState struct {
+	Status Status `json:"status"`
+	// Reported is the status reported by the module itself, Status differs from it when the
+	// module is blocked by a dependency
+	Reported Status `json:"reported"`
+	// Reason is a short human readable explanation of the status
+	Reason string `json:"reason,omitempty"`
+	// Since is when the module last changed status
+	Since time.Time `json:"since"`
+	// Updated is when the module last reported
+	Updated time.Time `json:"updated"`
+	// Details can hold module specific information, such as an endpoint or queue depth
+	Details map[string]string `json:"details,omitempty"`
+	// Liveness is set for modules included in liveness probes
+	Liveness bool `json:"liveness,omitempty"`
+	// Class determines if the module affects the aggregate state
+	Class Class `json:"class"`
+	// DependsOn lists the modules that must be up for this module to be up
+	DependsOn []string `json:"depends_on,omitempty"`
+	// BlockedBy lists the dependencies that are not up
+	BlockedBy []string `json:"blocked_by,omitempty"`
+	// Flapping is set while the module is held down for changing state too frequently
+	Flapping bool `json:"flapping,omitempty"`
+}
+
+func copyDetails(details map[string]string) (c map[string]string) {
+	if details == nil {
+		return nil
+	}
+	c = make(map[string]string, len(details))
+	for k, v := range details {
+		c[k] = v
+	}
+	return c
+}
+
+func (state ModuleState) clone() (c ModuleState) {
+	c = state
+	c.Details = copyDetails(state.Details)
+	c.DependsOn = append([]string(nil), state.DependsOn...)
+	c.BlockedBy = append([]string(nil), state.BlockedBy...)
+	return c
+}
+
+// Snapshot is a copy of the state of all modules at a point in time
+//
+type Snapshot struct {
+	// Up is the aggregate state as sent to listeners, true when every critical module is up or degraded
+	Up      bool                   `json:"up"`
+	Taken   time.Time              `json:"taken"`
+	Modules map[string]ModuleState `json:"modules"`
+}
+
+// Down returns the sorted names of the critical modules that are preventing the server from being up
+//
+func (snap *Snapshot) Down() (modules []string) {
+	modules = []string{}
+	for name, state := range snap.Modules {
+		if state.Class == Critical && !state.Status.IsUp() {
+			modules = append(modules, name)
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components // import "github.com/leaf-ai/go-service/pkg/components"
+
+// This file contains the implementation of subscriptions to module change events.  Unlike
+// listeners, which periodically receive the aggregate state, subscribers receive an event for
+// every change to a module as it happens.  Events are never sent while blocking the tracker,
+// subscribers that fall behind have events dropped according to their buffering policy.
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event describes a change to the state of a module
+//
+type Event struct {
+	Module string
+	Old    ModuleState
+	New    ModuleState
+	// Up is the aggregate state of the server after the change
+	Up   bool
+	Time time.Time
+}
+
+// Policy determines which events are discarded when a subscriber's buffer is full
+//
+type Policy int
+
+const (
+	// DropNewest discards events that arrive while the buffer is full, this is the default
+	DropNewest Policy = iota
+	// DropOldest discards the oldest buffered event to make room for a new one, useful for
+	// subscribers that only care about recent state
+	DropOldest
+)
+
+// SubscribeOpts contains the parameters used to create a subscription
+//
+type SubscribeOpts struct {
+	// Buffer is the number of events held for the subscriber, defaults to 16
+	Buffer int
+	// Policy selects the events discarded when the buffer is full
+	Policy Policy
+}
+
+// Subscription delivers change events to a subscriber using the C channel
+//
+type Subscription struct {
+	C <-chan Event
+
+	eventC  chan Event
+	policy  Policy
+	dropped uint64
+	comps   *Components
+	sync.Once
+}
+
+// Subscribe creates a subscription to module change events, the subscription remains active
+// until Unsubscribe is called
+//
+func (comps *Components) Subscribe(opts SubscribeOpts) (sub *Subscription) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 16
+	}
+	eventC := make(chan Event, opts.Buffer)
+	sub = &Subscription{
+		C:      eventC,
+		eventC: eventC,
+		policy: opts.Policy,
+		comps:  comps,
+	}
+
+	comps.Lock()
+	defer comps.Unlock()
+
+	// Start tracking published states so that the subscriber only sees changes from now on
+	if comps.published == nil {
+		comps.published = comps.resolve()
+	}
+	comps.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops the delivery of events and closes the subscription channel, it is safe to
+// call more than once
+//
+func (sub *Subscription) Unsubscribe() {
+	sub.Do(func() {
+		sub.comps.Lock()
+		defer sub.comps.Unlock()
+		delete(sub.comps.subscribers, sub)
+		close(sub.eventC)
+	})
+}
+
+// Dropped returns the number of events discarded because the subscriber fell behind
+//
+func (sub *Subscription) Dropped() (dropped uint64) {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// deliver sends an event without blocking, applying the subscriber's policy when the buffer
+// is full.  The caller is expected to hold the tracker lock which serializes senders.
+func (sub *Subscription) deliver(event Event) {
+	select {
+	case sub.eventC <- event:
+		return
+	default:
+	}
+
+	if sub.policy == DropOldest {
+		select {
+		case <-sub.eventC:
+			atomic.AddUint64(&sub.dropped, 1)
+		default:
+		}
+		select {
+		case sub.eventC <- event:
+			return
+		default:
+		}
+	}
+	atomic.AddUint64(&sub.dropped, 1)
+}
+
+// changed compares the current state of the modules with the state last published to
+// subscribers and delivers an event for each module that has changed, the caller is expected
+// to be holding the lock
+func (comps *Components) changed() {
+	defer comps.signal()
+
+	// The aggregate is evaluated on every change so that hysteresis sees every transition
+	current := comps.resolve()
+	up := comps.advanceAggregate(current)
+
+	if len(comps.subscribers) == 0 {
+		comps.published = nil
+		return
+	}
+
+	now := time.Now()
+
+	for name, state := range current {
+		old, isPresent := comps.published[name]
+		if isPresent && !isDifferent(old, state) {
+			continue
+		}
+		event := Event{
+			Module: name,
+			Old:    old,
+			New:    state,
+			Up:     up,
+			Time:   now,
+		}
+		for sub := range comps.subscribers {
+			sub.deliver(event)
+		}
+	}
+	comps.published = current
+}
+
+// isDifferent tests if two states for a module differ in ways a subscriber is interested in
+func isDifferent(old ModuleState, new ModuleState) bool {
+	if old.Status != new.Status || old.Reason != new.Reason || old.Class != new.Class || old.Liveness != new.Liveness || old.Flapping != new.Flapping {
+		return true
+	}
+	if len(old.BlockedBy) != len(new.BlockedBy) {
+		return true
+	}
+	for i := range old.BlockedBy {
+		if old.BlockedBy[i] != new.BlockedBy[i] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package components
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+)
+
+func nextEvent(ctx context.Context, t *testing.T, sub *Subscription) (event Event) {
+	select {
+	case event = <-sub.C:
+	case <-ctx.Done():
+		t.Fatal("event not received", "stack", stack.Trace().TrimRuntime())
+	}
+	return event
+}
+
+// TestSubscribe checks that subscribers receive change events including those caused by
+// dependencies, and can unsubscribe
+//
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+	comps.SetModule("queue", true)
+
+	sub := comps.Subscribe(SubscribeOpts{})
+
+	comps.SetModule("storage", true)
+	event := nextEvent(ctx, t, sub)
+	if event.Module != "storage" || event.Old.Status != Unknown || event.New.Status != Up || !event.Up {
+		t.Fatal("unexpected event", event, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Reports that do not change the module produce no events
+	comps.SetModule("storage", true)
+
+	if err := comps.DependsOn("queue", "storage"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	comps.SetStatus("storage", Down, "bucket missing", nil)
+
+	// Both the module and its dependent change
+	changes := map[string]Event{}
+	for i := 0; i != 2; i++ {
+		event = nextEvent(ctx, t, sub)
+		changes[event.Module] = event
+	}
+	if storage := changes["storage"]; storage.Old.Status != Up || storage.New.Reason != "bucket missing" || storage.Up {
+		t.Fatal("unexpected event", storage, "stack", stack.Trace().TrimRuntime())
+	}
+	if queue := changes["queue"]; queue.Old.Status != Up || queue.New.Status != Down || len(queue.New.BlockedBy) != 1 {
+		t.Fatal("unexpected dependent event", queue, "stack", stack.Trace().TrimRuntime())
+	}
+	select {
+	case event = <-sub.C:
+		t.Fatal("unexpected extra event", event, "stack", stack.Trace().TrimRuntime())
+	default:
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	comps.SetModule("storage", true)
+	if _, isOpen := <-sub.C; isOpen {
+		t.Fatal("event received after unsubscribing", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestSubscribePolicies checks the buffering policies and dropped event counts of subscribers
+// that fall behind
+//
+func TestSubscribePolicies(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+
+	newest := comps.Subscribe(SubscribeOpts{Buffer: 2, Policy: DropNewest})
+	defer newest.Unsubscribe()
+	oldest := comps.Subscribe(SubscribeOpts{Buffer: 2, Policy: DropOldest})
+	defer oldest.Unsubscribe()
+
+	for _, module := range []string{"a", "b", "c", "d", "e"} {
+		comps.SetModule(module, true)
+	}
+
+	if newest.Dropped() != 3 || oldest.Dropped() != 3 {
+		t.Fatal("unexpected drop counts", newest.Dropped(), oldest.Dropped(), "stack", stack.Trace().TrimRuntime())
+	}
+	if first, second := nextEvent(ctx, t, newest), nextEvent(ctx, t, newest); first.Module != "a" || second.Module != "b" {
+		t.Fatal("unexpected events kept", first.Module, second.Module, "stack", stack.Trace().TrimRuntime())
+	}
+	if first, second := nextEvent(ctx, t, oldest), nextEvent(ctx, t, oldest); first.Module != "d" || second.Module != "e" {
+		t.Fatal("unexpected events kept", first.Module, second.Module, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestListenerRemoval checks that listeners can be removed explicitly or by closing them
+//
+func TestListenerRemoval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	comps := InitComponentTracking(ctx)
+
+	removed := make(chan bool, 1)
+	closed := make(chan bool, 1)
+	kept := make(chan bool, 1)
+	comps.AddListener(removed)
+	comps.AddListener(closed)
+	comps.AddListener(kept)
+
+	comps.RemoveListener(removed)
+	close(closed)
+
+	comps.SetModule("queue", true)
+	waitListener(ctx, t, kept, true)
+
+	comps.SetModule("queue", false)
+	waitListener(ctx, t, kept, false)
+
+	comps.Lock()
+	remaining := len(comps.listeners)
+	comps.Unlock()
+	if remaining != 1 {
+		t.Fatal("listeners not removed", remaining, "stack", stack.Trace().TrimRuntime())
+	}
+	select {
+	case <-removed:
+		t.Fatal("removed listener received state", "stack", stack.Trace().TrimRuntime())
+	default:
+	}
+}
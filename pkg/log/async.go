@@ -0,0 +1,261 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains the asynchronous mode of a logger.  Messages are placed onto a bounded
+// queue and written by a goroutine so that a slow output, for example a blocked pipe, does
+// not stall the goroutines doing the logging.  When the queue is full messages are either
+// waited on, or dropped and counted, according to the policy selected.
+//
+// The writing goroutine never takes the logger lock, callers queueing messages can be
+// holding it while waiting on a full queue.  Instead each message carries a copy of the
+// output settings in effect when it was queued.
+
+import (
+	"sync"
+	"sync/atomic"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// OverflowPolicy selects what happens to messages logged while the queue of an asynchronous
+// logger is full
+//
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the message being logged
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest message in the queue to make room
+	OverflowDropOldest
+)
+
+// DefaultQueueSize is the number of messages an asynchronous logger queues when no size is
+// supplied
+//
+const DefaultQueueSize = 1024
+
+// AsyncOpts contains the parameters of an asynchronous logger
+//
+type AsyncOpts struct {
+	QueueSize int            // Messages that can be waiting to be written, defaults to DefaultQueueSize
+	Overflow  OverflowPolicy // What happens when the queue is full, fatal messages always wait
+}
+
+// queued is a message waiting to be written, along with a logger holding the output settings
+// to be used
+type queued struct {
+	entry  *Entry
+	writer *Logger
+}
+
+// async holds the queue and counters of an asynchronous logger
+type async struct {
+	opts    AsyncOpts
+	queue   chan queued
+	text    logxi.Logger // Text output, used only by the writing goroutine
+	done    chan struct{}
+	dropped *uint64 // Counter held by the logger so that it outlives the queue, updated atomically
+
+	added   uint64 // Messages given to the queue
+	retired uint64 // Messages written or dropped
+	sync.Mutex
+	cond *sync.Cond
+}
+
+// queues holds the queues of every asynchronous logger for FlushAll, queues are removed when
+// their logger is closed or its options replaced
+var queues = struct {
+	active map[*async]struct{}
+	sync.Mutex
+}{
+	active: map[*async]struct{}{},
+}
+
+// SetAsync switches the logger to asynchronous output.  If the logger is already asynchronous
+// the messages already queued are written before the new options take effect.  The mode is
+// shared with child loggers.
+//
+func (l *Logger) SetAsync(opts AsyncOpts) {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	a := &async{
+		opts:  opts,
+		queue: make(chan queued, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+	a.cond = sync.NewCond(&a.Mutex)
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.stopAsync()
+	a.dropped = &l.dropped
+	l.async = a
+	go a.run()
+
+	queues.Lock()
+	queues.active[a] = struct{}{}
+	queues.Unlock()
+}
+
+// Flush waits until the messages logged before it was called have been written, or dropped.
+// Loggers that are not asynchronous return immediately.
+//
+func (l *Logger) Flush() {
+	l.Lock()
+	a := l.async
+	l.Unlock()
+
+	if a != nil {
+		a.flush()
+	}
+}
+
+// Close writes any queued messages and then returns the logger to synchronous output.  A
+// registered logger is also removed from the registry, it remains usable but is no longer
+// affected by changes to the levels of its component.  Child loggers share the state being
+// closed.
+//
+func (l *Logger) Close() {
+	unregister(l.shared)
+
+	l.Lock()
+	defer l.Unlock()
+	l.stopAsync()
+}
+
+// Dropped returns the number of messages that have been discarded because the queue was
+// full, counting from when the logger was first made asynchronous.  The count is retained
+// when the logger is closed.
+//
+func (l *Logger) Dropped() (dropped uint64) {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// FlushAll flushes every asynchronous logger, for use when a server is shutting down
+//
+func FlushAll() {
+	queues.Lock()
+	active := make([]*async, 0, len(queues.active))
+	for a := range queues.active {
+		active = append(active, a)
+	}
+	queues.Unlock()
+
+	for _, a := range active {
+		a.flush()
+	}
+}
+
+// stopAsync writes the queued messages and stops the writing goroutine, the caller is
+// expected to be holding the logger lock so that no messages are written synchronously
+// until the queue is empty
+func (l *Logger) stopAsync() {
+	if l.async == nil {
+		return
+	}
+	queues.Lock()
+	delete(queues.active, l.async)
+	queues.Unlock()
+
+	close(l.async.queue)
+	<-l.async.done
+	l.async = nil
+}
+
+// deliver passes an entry to the sinks and writes it, or queues it when the logger is
+// asynchronous.  The caller is expected to be holding the logger lock.
+func (l *Logger) deliver(entry *Entry) {
+	if l.async == nil {
+		l.dispatch(entry)
+		l.write(entry)
+		return
+	}
+
+	if l.async.text == nil {
+		l.async.text = logxi.NewLogger(logxi.NewConcurrentWriter(l.out), l.component)
+		l.async.text.SetLevel(logxi.LevelAll)
+	}
+	writer := &Logger{
+		shared: &shared{
+			log:       l.async.text,
+			out:       l.out,
+			format:    l.format,
+			handler:   l.handler,
+			component: l.component,
+			sinks:     l.sinks,
+		},
+	}
+	l.async.add(queued{entry: entry, writer: writer}, entry.Level <= logxi.LevelFatal)
+}
+
+// add places a message onto the queue, applying the overflow policy unless wait is set
+func (a *async) add(msg queued, wait bool) {
+	a.Lock()
+	a.added++
+	a.Unlock()
+
+	switch {
+	case wait || a.opts.Overflow == OverflowBlock:
+		a.queue <- msg
+	case a.opts.Overflow == OverflowDropNewest:
+		select {
+		case a.queue <- msg:
+		default:
+			a.retire(true)
+		}
+	default:
+		// Only callers holding the logger lock add messages, so once room has been made
+		// the next attempt can only fail if the writer has not yet removed a message
+		for {
+			select {
+			case a.queue <- msg:
+				return
+			default:
+			}
+			select {
+			case <-a.queue:
+				a.retire(true)
+			default:
+			}
+		}
+	}
+}
+
+// retire counts a message that has been written or dropped, releasing any flushes waiting
+// on it
+func (a *async) retire(dropped bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.retired++
+	if dropped {
+		atomic.AddUint64(a.dropped, 1)
+	}
+	a.cond.Broadcast()
+}
+
+// flush waits for the messages added so far to be retired
+func (a *async) flush() {
+	a.Lock()
+	defer a.Unlock()
+
+	for target := a.added; a.retired < target; {
+		a.cond.Wait()
+	}
+}
+
+// run writes queued messages until the queue is closed
+func (a *async) run() {
+	defer close(a.done)
+
+	for msg := range a.queue {
+		msg.writer.dispatch(msg.entry)
+		msg.writer.write(msg.entry)
+		a.retire(false)
+	}
+}
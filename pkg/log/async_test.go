@@ -0,0 +1,160 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// gatedWriter holds every write until it is released, simulating a blocked pipe
+type gatedWriter struct {
+	started chan struct{}
+	release chan struct{}
+	out     bytes.Buffer
+	sync.Mutex
+}
+
+func newGatedWriter() (w *gatedWriter) {
+	return &gatedWriter{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (w *gatedWriter) Write(p []byte) (n int, errGo error) {
+	select {
+	case w.started <- struct{}{}:
+	default:
+	}
+	<-w.release
+
+	w.Lock()
+	defer w.Unlock()
+	return w.out.Write(p)
+}
+
+func (w *gatedWriter) String() (out string) {
+	w.Lock()
+	defer w.Unlock()
+	return w.out.String()
+}
+
+// TestAsyncOverflow checks that logging does not wait on a blocked output and that messages
+// are dropped and counted according to the overflow policy
+//
+func TestAsyncOverflow(t *testing.T) {
+	for policy, expected := range map[OverflowPolicy][]string{
+		OverflowDropNewest: {"message 0", "message 1", "message 2"},
+		OverflowDropOldest: {"message 0", "message 4", "message 5"},
+	} {
+		w := newGatedWriter()
+
+		logger := NewLogger("async-overflow")
+		logger.SetOutput(w)
+		logger.SetLevel(logxi.LevelInfo)
+		logger.SetFormat(FormatJSON)
+		logger.SetAsync(AsyncOpts{QueueSize: 2, Overflow: policy})
+
+		// Wait for the first message to be held by the writer so that the queue is empty
+		logger.Info("message 0")
+		select {
+		case <-w.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not written", policy, "stack", stack.Trace().TrimRuntime())
+		}
+
+		for i := 1; i <= 5; i++ {
+			logger.Info(fmt.Sprint("message ", i))
+		}
+		if dropped := logger.Dropped(); dropped != 3 {
+			t.Fatal("unexpected drop count", policy, dropped, "stack", stack.Trace().TrimRuntime())
+		}
+
+		close(w.release)
+		FlushAll()
+
+		lines := decodeLines(t, bytes.NewBufferString(w.String()))
+		if len(lines) != len(expected) {
+			t.Fatal("unexpected output", policy, w.String(), "stack", stack.Trace().TrimRuntime())
+		}
+		for i, msg := range expected {
+			if lines[i][FieldMsg] != msg {
+				t.Fatal("unexpected message", policy, i, lines[i], "stack", stack.Trace().TrimRuntime())
+			}
+		}
+
+		// The count is kept once the logger returns to synchronous output
+		logger.Close()
+		if dropped := logger.Dropped(); dropped != 3 {
+			t.Fatal("drop count lost on close", policy, dropped, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestAsyncClose checks that a blocking logger delivers every message when it is closed, and
+// then returns to synchronous output
+//
+func TestAsyncClose(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewLogger("async-close")
+	logger.SetOutput(out)
+	logger.SetLevel(logxi.LevelInfo)
+	logger.SetAsync(AsyncOpts{QueueSize: 4})
+
+	child := logger.With("worker", 1)
+	for i := 0; i != 100; i++ {
+		child.Info("queued message", "seq", i)
+	}
+	logger.Close()
+
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 100 || !strings.Contains(lines[99], "queued message") {
+		t.Fatal("messages not delivered", len(lines), "stack", stack.Trace().TrimRuntime())
+	}
+	if dropped := logger.Dropped(); dropped != 0 {
+		t.Fatal("messages dropped", dropped, "stack", stack.Trace().TrimRuntime())
+	}
+
+	logger.Info("direct message")
+	if !strings.Contains(out.String(), "direct message") {
+		t.Fatal("message not written after close", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestAsyncFatal checks that queued messages and the fatal message itself are written before
+// Fatal panics, even when messages would otherwise be dropped
+//
+func TestAsyncFatal(t *testing.T) {
+	w := newGatedWriter()
+	close(w.release)
+
+	logger := NewLogger("async-fatal")
+	logger.SetOutput(w)
+	logger.SetFormat(FormatJSON)
+	logger.SetAsync(AsyncOpts{QueueSize: 1, Overflow: OverflowDropNewest})
+	defer logger.Close()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("fatal did not panic", "stack", stack.Trace().TrimRuntime())
+		}
+		lines := decodeLines(t, bytes.NewBufferString(w.String()))
+		if len(lines) == 0 || lines[len(lines)-1][FieldMsg] != "stopping" || lines[len(lines)-1][FieldLevel] != LevelName(logxi.LevelFatal) {
+			t.Fatal("fatal message not written", w.String(), "stack", stack.Trace().TrimRuntime())
+		}
+	}()
+
+	for i := 0; i != 10; i++ {
+		logger.Info("working", "seq", i)
+	}
+	logger.Fatal("stopping")
+}
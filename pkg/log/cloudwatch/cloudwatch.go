@@ -0,0 +1,435 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package cloudwatch // import "github.com/leaf-ai/go-service/pkg/log/cloudwatch"
+
+// This file contains the implementation of a log sink that ships messages to AWS CloudWatch Logs
+// for servers that are hosted outside of environments that collect console output.  Messages
+// are buffered in memory, up to a fixed limit after which they are dropped and counted, and are
+// sent in batches that respect the CloudWatch Logs size and count limits.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/log"
+)
+
+const (
+	// maxBatchCount is the largest number of events CloudWatch accepts in a single request
+	maxBatchCount = 10000
+	// maxBatchBytes is the largest request CloudWatch accepts, events are charged their
+	// message size plus eventOverhead bytes
+	maxBatchBytes = 1048576
+	eventOverhead = 26
+	// maxEventBytes is the largest single message CloudWatch will accept
+	maxEventBytes = 256*1024 - eventOverhead
+	// maxBackoff caps the delay between retries of a failed batch
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	invalidGroupChars  = regexp.MustCompile(`[^\.\-_/#A-Za-z0-9]`)
+	invalidStreamChars = regexp.MustCompile(`[:\*]`)
+)
+
+// Opts contains the parameters used to create a CloudWatch Logs sink, zero values
+// are replaced with defaults
+//
+type Opts struct {
+	// Group is the log group name, defaults to the component name
+	Group string
+	// Stream is the log stream name, defaults to the host name and process ID
+	Stream string
+	// Endpoint can be used to override the AWS service endpoint, for example when using a
+	// local stand-in
+	Endpoint string
+	// FlushInterval is the longest period a message is held before being sent, default 5 seconds
+	FlushInterval time.Duration
+	// MaxBatchCount is the largest number of messages sent in one request, default 10000
+	MaxBatchCount int
+	// MaxBatchBytes is the largest request size, default 1MiB
+	MaxBatchBytes int
+	// MaxBuffered is the number of messages held while waiting to be sent before new messages
+	// are dropped, default 10000
+	MaxBuffered int
+	// Retries is the number of times a failed batch is retried before being dropped, default 5
+	Retries int
+	// Backoff is the delay before the first retry, doubling for each retry after that, default 250ms
+	Backoff time.Duration
+}
+
+// Stats contains the counts of messages processed by a sink
+//
+type Stats struct {
+	Sent    uint64
+	Dropped uint64
+}
+
+// Sink is a log.Sink that sends messages to a CloudWatch Logs stream
+//
+type Sink struct {
+	Group  string
+	Stream string
+
+	opts     Opts
+	client   *cloudwatchlogs.CloudWatchLogs
+	sequence *string
+	created  bool
+
+	entryC chan *cloudwatchlogs.InputLogEvent
+	flushC chan chan struct{}
+	doneC  chan struct{}
+	errorC chan<- kv.Error
+
+	sent    uint64
+	dropped uint64
+}
+
+// GroupName converts a component name into a valid log group name
+//
+func GroupName(component string) (name string) {
+	name = invalidGroupChars.ReplaceAllString(component, "_")
+	if len(name) == 0 {
+		name = "gsc"
+	}
+	if len(name) > 512 {
+		name = name[:512]
+	}
+	return name
+}
+
+// StreamName generates a valid log stream name from a host name, adding the process ID
+// so that multiple processes on one host do not contend for the stream sequence
+//
+func StreamName(host string) (name string) {
+	name = invalidStreamChars.ReplaceAllString(fmt.Sprintf("%s/%d", host, os.Getpid()), "_")
+	if len(name) > 512 {
+		name = name[:512]
+	}
+	return name
+}
+
+// New creates a sink for the named component and starts its background sender.  Once the
+// ctx is Done() buffered messages are sent and the sender stops.
+//
+// Errors encountered while sending are reported using the errorC channel, messages are never
+// logged by the sink as doing so could recurse.
+//
+func New(ctx context.Context, cred *aws_gsc.AWSCred, component string, opts Opts, errorC chan<- kv.Error) (sink *Sink, err kv.Error) {
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.MaxBatchCount <= 0 || opts.MaxBatchCount > maxBatchCount {
+		opts.MaxBatchCount = maxBatchCount
+	}
+	if opts.MaxBatchBytes <= 0 || opts.MaxBatchBytes > maxBatchBytes {
+		opts.MaxBatchBytes = maxBatchBytes
+	}
+	if opts.MaxBuffered <= 0 {
+		opts.MaxBuffered = 10000
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 250 * time.Millisecond
+	}
+
+	sink = &Sink{
+		Group:  opts.Group,
+		Stream: opts.Stream,
+		opts:   opts,
+		entryC: make(chan *cloudwatchlogs.InputLogEvent, opts.MaxBuffered),
+		flushC: make(chan chan struct{}),
+		doneC:  make(chan struct{}),
+		errorC: errorC,
+	}
+	if len(sink.Group) == 0 {
+		sink.Group = GroupName(component)
+	}
+	if len(sink.Stream) == 0 {
+		host, _ := os.Hostname()
+		sink.Stream = StreamName(host)
+	}
+
+	cfg := &aws.Config{
+		Region:      aws.String(cred.Region),
+		Credentials: cred.Creds,
+		HTTPClient:  &http.Client{},
+		// Retries are handled by the sink so that it can apply its own backoff
+		MaxRetries: aws.Int(0),
+	}
+	if len(opts.Endpoint) != 0 {
+		cfg.Endpoint = aws.String(opts.Endpoint)
+	}
+	sess, errGo := session.NewSession(cfg)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("group", sink.Group, "stream", sink.Stream).With("stack", stack.Trace().TrimRuntime())
+	}
+	sink.client = cloudwatchlogs.New(sess)
+
+	go sink.run(ctx)
+
+	return sink, nil
+}
+
+// Write queues a log entry for sending, if the buffer is full the entry is dropped.  It
+// never blocks and is safe for concurrent use, so one sink can be added to any number of
+// synchronous or asynchronous loggers.
+//
+func (sink *Sink) Write(entry *log.Entry) {
+	msg, errGo := json.Marshal(entry)
+	if errGo != nil {
+		msg = []byte(fmt.Sprint(entry.Msg, " ", entry.Args))
+	}
+	msg = truncate(msg, maxEventBytes)
+
+	event := &cloudwatchlogs.InputLogEvent{
+		Message:   aws.String(string(msg)),
+		Timestamp: aws.Int64(entry.Time.UnixNano() / int64(time.Millisecond)),
+	}
+
+	select {
+	case sink.entryC <- event:
+	default:
+		atomic.AddUint64(&sink.dropped, 1)
+	}
+}
+
+// Stats returns the number of messages sent and dropped by the sink
+//
+func (sink *Sink) Stats() (stats Stats) {
+	return Stats{
+		Sent:    atomic.LoadUint64(&sink.sent),
+		Dropped: atomic.LoadUint64(&sink.dropped),
+	}
+}
+
+// Flush blocks until messages written before it was called have been sent, or have
+// been dropped after failing, or the ctx is Done().  Messages logged by an asynchronous
+// logger only reach the sink once written from its queue, so the logger should be flushed
+// first.
+//
+func (sink *Sink) Flush(ctx context.Context) {
+	doneC := make(chan struct{})
+	select {
+	case sink.flushC <- doneC:
+	case <-sink.doneC:
+		return
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-doneC:
+	case <-ctx.Done():
+	}
+}
+
+// Done returns a channel that is closed once the sink has stopped and its buffered
+// messages have been sent
+//
+func (sink *Sink) Done() (doneC <-chan struct{}) {
+	return sink.doneC
+}
+
+func (sink *Sink) report(err kv.Error) {
+	select {
+	case sink.errorC <- err.With("group", sink.Group, "stream", sink.Stream):
+	default:
+	}
+}
+
+func (sink *Sink) run(ctx context.Context) {
+	defer close(sink.doneC)
+
+	batch := []*cloudwatchlogs.InputLogEvent{}
+	batchBytes := 0
+
+	send := func(ctx context.Context) {
+		if len(batch) != 0 {
+			sink.send(ctx, batch)
+		}
+		batch = []*cloudwatchlogs.InputLogEvent{}
+		batchBytes = 0
+	}
+	add := func(ctx context.Context, event *cloudwatchlogs.InputLogEvent) {
+		size := len(aws.StringValue(event.Message)) + eventOverhead
+		if len(batch) != 0 && batchBytes+size > sink.opts.MaxBatchBytes {
+			send(ctx)
+		}
+		batch = append(batch, event)
+		batchBytes += size
+		if len(batch) >= sink.opts.MaxBatchCount {
+			send(ctx)
+		}
+	}
+	drain := func(ctx context.Context) {
+		for {
+			select {
+			case event := <-sink.entryC:
+				add(ctx, event)
+			default:
+				send(ctx)
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(sink.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-sink.entryC:
+			add(ctx, event)
+		case <-ticker.C:
+			send(ctx)
+		case doneC := <-sink.flushC:
+			drain(ctx)
+			close(doneC)
+		case <-ctx.Done():
+			// Give the remaining messages a limited time to be delivered
+			stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			drain(stopCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// ensureStream creates the log group and stream if they do not already exist
+func (sink *Sink) ensureStream(ctx context.Context) (err kv.Error) {
+	if sink.created {
+		return nil
+	}
+
+	alreadyExists := func(errGo error) bool {
+		awsErr := awserr.Error(nil)
+		return errors.As(errGo, &awsErr) && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException
+	}
+
+	if _, errGo := sink.client.CreateLogGroupWithContext(ctx, &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(sink.Group),
+	}); errGo != nil && !alreadyExists(errGo) {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	if _, errGo := sink.client.CreateLogStreamWithContext(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(sink.Group),
+		LogStreamName: aws.String(sink.Stream),
+	}); errGo != nil && !alreadyExists(errGo) {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	sink.created = true
+	return nil
+}
+
+// truncate shortens a message to no more than limit bytes without splitting a UTF-8 encoded
+// character
+func truncate(msg []byte, limit int) (truncated []byte) {
+	if len(msg) <= limit {
+		return msg
+	}
+	for limit > 0 && !utf8.RuneStart(msg[limit]) {
+		limit--
+	}
+	return msg[:limit]
+}
+
+// send delivers a batch of events, retrying with backoff, and dropping the batch if
+// it cannot be delivered
+func (sink *Sink) send(ctx context.Context, batch []*cloudwatchlogs.InputLogEvent) {
+
+	// CloudWatch requires the events within a batch to be in chronological order
+	sort.SliceStable(batch, func(i, j int) bool {
+		return aws.Int64Value(batch[i].Timestamp) < aws.Int64Value(batch[j].Timestamp)
+	})
+
+	backoff := sink.opts.Backoff
+	resequenced := 0
+	immediate := false
+	var err kv.Error
+
+retry:
+	for attempt := 0; attempt <= sink.opts.Retries; attempt++ {
+		if attempt != 0 && !immediate {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				break retry
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		immediate = false
+
+		if err = sink.ensureStream(ctx); err != nil {
+			continue
+		}
+
+		output, errGo := sink.client.PutLogEventsWithContext(ctx, &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(sink.Group),
+			LogStreamName: aws.String(sink.Stream),
+			LogEvents:     batch,
+			SequenceToken: sink.sequence,
+		})
+		if errGo == nil {
+			sink.sequence = output.NextSequenceToken
+			atomic.AddUint64(&sink.sent, uint64(len(batch)))
+			if rejected := output.RejectedLogEventsInfo; rejected != nil {
+				sink.report(kv.NewError("log events rejected").With("info", rejected.String()).With("stack", stack.Trace().TrimRuntime()))
+			}
+			return
+		}
+
+		// Sequence errors carry the token that is expected next, these are retried immediately
+		// and a limited number of times without counting as an attempt
+		badSequence := &cloudwatchlogs.InvalidSequenceTokenException{}
+		if errors.As(errGo, &badSequence) && resequenced < 3 {
+			resequenced++
+			sink.sequence = badSequence.ExpectedSequenceToken
+			attempt--
+			immediate = true
+			continue
+		}
+		accepted := &cloudwatchlogs.DataAlreadyAcceptedException{}
+		if errors.As(errGo, &accepted) {
+			sink.sequence = accepted.ExpectedSequenceToken
+			atomic.AddUint64(&sink.sent, uint64(len(batch)))
+			return
+		}
+		notFound := &cloudwatchlogs.ResourceNotFoundException{}
+		if errors.As(errGo, &notFound) {
+			sink.created = false
+			sink.sequence = nil
+		}
+		err = kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	atomic.AddUint64(&sink.dropped, uint64(len(batch)))
+	if err == nil {
+		err = kv.NewError("log batch abandoned").With("stack", stack.Trace().TrimRuntime())
+	}
+	sink.report(err.With("dropped", len(batch)))
+}
@@ -0,0 +1,321 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package cloudwatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+	logxi "github.com/karlmutch/logxi/v1"
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/log"
+)
+
+// logsStandIn implements the subset of the CloudWatch Logs JSON protocol used by the sink
+type logsStandIn struct {
+	groups   map[string]bool
+	streams  map[string]int
+	events   []string
+	puts     int
+	failNext int
+	// gateC, when set, is received from before each put is answered
+	gateC chan struct{}
+	putC  chan struct{}
+	sync.Mutex
+}
+
+func newLogsStandIn() (s *logsStandIn) {
+	return &logsStandIn{
+		groups:  map[string]bool{},
+		streams: map[string]int{},
+		putC:    make(chan struct{}, 100),
+	}
+}
+
+func (s *logsStandIn) fail(w http.ResponseWriter, status int, code string, extra map[string]interface{}) {
+	body := map[string]interface{}{"__type": code, "message": code}
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *logsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		LogGroupName  string `json:"logGroupName"`
+		LogStreamName string `json:"logStreamName"`
+		SequenceToken string `json:"sequenceToken"`
+		LogEvents     []struct {
+			Message   string `json:"message"`
+			Timestamp int64  `json:"timestamp"`
+		} `json:"logEvents"`
+	}{}
+	if errGo := json.NewDecoder(r.Body).Decode(&request); errGo != nil {
+		http.Error(w, errGo.Error(), http.StatusBadRequest)
+		return
+	}
+
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+	if action == "PutLogEvents" {
+		select {
+		case s.putC <- struct{}{}:
+		default:
+		}
+		if s.gateC != nil {
+			<-s.gateC
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	streamKey := request.LogGroupName + "|" + request.LogStreamName
+
+	switch action {
+	case "CreateLogGroup":
+		if s.groups[request.LogGroupName] {
+			s.fail(w, http.StatusBadRequest, "ResourceAlreadyExistsException", nil)
+			return
+		}
+		s.groups[request.LogGroupName] = true
+	case "CreateLogStream":
+		if !s.groups[request.LogGroupName] {
+			s.fail(w, http.StatusBadRequest, "ResourceNotFoundException", nil)
+			return
+		}
+		if _, isPresent := s.streams[streamKey]; isPresent {
+			s.fail(w, http.StatusBadRequest, "ResourceAlreadyExistsException", nil)
+			return
+		}
+		s.streams[streamKey] = 0
+	case "PutLogEvents":
+		s.puts++
+		if s.failNext > 0 {
+			s.failNext--
+			s.fail(w, http.StatusServiceUnavailable, "ServiceUnavailableException", nil)
+			return
+		}
+		sequence, isPresent := s.streams[streamKey]
+		if !isPresent {
+			s.fail(w, http.StatusBadRequest, "ResourceNotFoundException", nil)
+			return
+		}
+		if request.SequenceToken != fmt.Sprint(sequence) {
+			s.fail(w, http.StatusBadRequest, "InvalidSequenceTokenException", map[string]interface{}{
+				"expectedSequenceToken": fmt.Sprint(sequence),
+			})
+			return
+		}
+		last := int64(0)
+		for _, event := range request.LogEvents {
+			if event.Timestamp < last {
+				s.fail(w, http.StatusBadRequest, "InvalidParameterException", nil)
+				return
+			}
+			last = event.Timestamp
+			s.events = append(s.events, event.Message)
+		}
+		s.streams[streamKey] = sequence + 1
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"nextSequenceToken": fmt.Sprint(sequence + 1),
+		})
+		return
+	default:
+		http.Error(w, "unsupported action", http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte("{}"))
+}
+
+func testCred() (cred *aws_gsc.AWSCred) {
+	return &aws_gsc.AWSCred{
+		Project: "test",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("test_access_key", "test_secret_key", ""),
+	}
+}
+
+// TestSink checks that logger messages are delivered in batches while recovering from
+// sequence token mismatches and transient failures
+//
+func TestSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	standIn := newLogsStandIn()
+	// The stream already exists and has a sequence the sink does not know about
+	standIn.groups["runner"] = true
+	standIn.streams["runner|host/1"] = 5
+	standIn.failNext = 2
+
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	errorC := make(chan kv.Error, 10)
+	sink, err := New(ctx, testCred(), "runner", Opts{
+		Stream:        "host/1",
+		Endpoint:      server.URL,
+		MaxBatchCount: 3,
+		Backoff:       10 * time.Millisecond,
+	}, errorC)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	logger := log.NewLogger("runner")
+	logger.SetLevel(logxi.LevelInfo)
+	logger.AddSink(sink)
+
+	for i := 0; i != 7; i++ {
+		logger.Info("message", "index", i)
+	}
+	logger.Debug("filtered")
+
+	sink.Flush(ctx)
+
+	standIn.Lock()
+	events := append([]string{}, standIn.events...)
+	puts := standIn.puts
+	standIn.Unlock()
+
+	if len(events) != 7 || puts < 3 {
+		t.Fatal("unexpected delivery", len(events), puts, "stack", stack.Trace().TrimRuntime())
+	}
+	for i, event := range events {
+		fields := map[string]interface{}{}
+		if errGo := json.Unmarshal([]byte(event), &fields); errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		if fields["msg"] != "message" || fields["index"] != float64(i) || fields["component"] != "runner" || fields["level"] != "INF" {
+			t.Fatal("unexpected event", event, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+	if stats := sink.Stats(); stats.Sent != 7 || stats.Dropped != 0 {
+		t.Fatal("unexpected stats", stats, "stack", stack.Trace().TrimRuntime())
+	}
+	select {
+	case err = <-errorC:
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	default:
+	}
+
+	// Shutting down delivers anything still buffered
+	logger.Warn("final")
+	cancel()
+	<-sink.Done()
+	if stats := sink.Stats(); stats.Sent != 8 {
+		t.Fatal("buffered messages lost on shutdown", stats, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestSinkDrops checks that a sink bounds the messages it holds and counts those it drops
+//
+func TestSinkDrops(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	standIn := newLogsStandIn()
+	standIn.gateC = make(chan struct{})
+
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	errorC := make(chan kv.Error, 10)
+	sink, err := New(ctx, testCred(), "runner", Opts{
+		Endpoint:      server.URL,
+		MaxBatchCount: 1,
+		MaxBuffered:   2,
+		Retries:       -1,
+	}, errorC)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	entry := func(msg string) *log.Entry {
+		return &log.Entry{Time: time.Now(), Level: logxi.LevelError, Component: "runner", Msg: msg}
+	}
+
+	// The first message is stuck being sent while the remainder overflow the buffer
+	sink.Write(entry("first"))
+	select {
+	case <-standIn.putC:
+	case <-ctx.Done():
+		t.Fatal("message not sent", "stack", stack.Trace().TrimRuntime())
+	}
+	for i := 0; i != 5; i++ {
+		sink.Write(entry(fmt.Sprint("overflow ", i)))
+	}
+	close(standIn.gateC)
+
+	sink.Flush(ctx)
+
+	if stats := sink.Stats(); stats.Sent != 3 || stats.Dropped != 3 {
+		t.Fatal("unexpected stats", stats, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestSinkResequence checks that a sequence token mismatch is retried without waiting for the
+// backoff that follows a failed attempt
+//
+func TestSinkResequence(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	standIn := newLogsStandIn()
+	// The first put fails and the retry carries a stale sequence token
+	standIn.groups["runner"] = true
+	standIn.streams["runner|host/1"] = 5
+	standIn.failNext = 1
+
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	backoff := 500 * time.Millisecond
+	sink, err := New(ctx, testCred(), "runner", Opts{
+		Stream:   "host/1",
+		Endpoint: server.URL,
+		Backoff:  backoff,
+	}, nil)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	started := time.Now()
+	sink.Write(&log.Entry{Time: time.Now(), Level: logxi.LevelError, Component: "runner", Msg: "resequenced"})
+	sink.Flush(ctx)
+
+	// A second backoff would have doubled to a full second
+	if elapsed := time.Since(started); elapsed >= 2*backoff {
+		t.Fatal("resequenced retry waited for backoff", elapsed, "stack", stack.Trace().TrimRuntime())
+	}
+	if stats := sink.Stats(); stats.Sent != 1 {
+		t.Fatal("message not delivered", stats, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestTruncate checks that oversized messages are not split within a UTF-8 character
+//
+func TestTruncate(t *testing.T) {
+	msg := []byte(strings.Repeat("\u20ac", maxEventBytes))
+	truncated := truncate(msg, maxEventBytes)
+	if len(truncated) > maxEventBytes || len(truncated) < maxEventBytes-utf8.UTFMax || !utf8.Valid(truncated) {
+		t.Fatal("invalid truncation", len(truncated), utf8.Valid(truncated), "stack", stack.Trace().TrimRuntime())
+	}
+	if short := truncate([]byte("short"), maxEventBytes); string(short) != "short" {
+		t.Fatal("short message altered", string(short), "stack", stack.Trace().TrimRuntime())
+	}
+}
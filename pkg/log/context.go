@@ -0,0 +1,51 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains child loggers, which add bound fields such as an experiment ID or a
+// queue name to every message, and a context carrier that allows a request scoped logger to
+// be retrieved deep within a call chain without being passed explicitly.
+
+import (
+	"context"
+)
+
+// With returns a child logger that adds the supplied label and value pairs to the start of
+// every message.  The child shares the output, format, sinks and level of its parent, changes
+// made to any of these using the child or the parent apply to both.
+//
+// A trailing label without a value is bound using the _extra label.
+//
+func (l *Logger) With(args ...interface{}) (child *Logger) {
+	bound := make([]interface{}, 0, len(l.bound)+len(args)+1)
+	bound = append(bound, l.bound...)
+	if len(args)%2 != 0 {
+		bound = append(bound, args[:len(args)-1]...)
+		bound = append(bound, FieldExtra, args[len(args)-1])
+	} else {
+		bound = append(bound, args...)
+	}
+
+	return &Logger{
+		shared: l.shared,
+		bound:  bound,
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context that carries the logger
+//
+func NewContext(ctx context.Context, logger *Logger) (loggerCtx context.Context) {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or the fallback logger if the
+// context has none
+//
+func FromContext(ctx context.Context, fallback *Logger) (logger *Logger) {
+	if logger, isPresent := ctx.Value(contextKey{}).(*Logger); isPresent && logger != nil {
+		return logger
+	}
+	return fallback
+}
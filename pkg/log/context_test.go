@@ -0,0 +1,69 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-stack/stack"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// TestWith checks that child loggers add their bound fields and share their parent's output
+// and level
+//
+func TestWith(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	parent := NewLogger("runner")
+	parent.SetOutput(out)
+	parent.SetFormat(FormatJSON)
+	parent.SetLevel(logxi.LevelInfo)
+
+	child := parent.With("experiment", "e-1")
+	grandchild := child.With("queue", "work", "dangling")
+
+	grandchild.Info("started", "attempt", 1)
+	parent.Info("parent")
+
+	// Level changes made through a child are seen by the parent
+	child.SetLevel(logxi.LevelWarn)
+	parent.Info("filtered")
+	grandchild.Info("filtered")
+
+	lines := decodeLines(t, out)
+	if len(lines) != 2 {
+		t.Fatal("unexpected output", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+	for key, expected := range map[string]interface{}{
+		"experiment": "e-1",
+		"queue":      "work",
+		FieldExtra:   "dangling",
+		"attempt":    float64(1),
+	} {
+		if lines[0][key] != expected {
+			t.Fatal("unexpected field", key, lines[0][key], out.String(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+	if _, isPresent := lines[1]["experiment"]; isPresent {
+		t.Fatal("child fields added to parent", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestContext checks that loggers can be carried by a context
+//
+func TestContext(t *testing.T) {
+	fallback := NewLogger("runner")
+	if FromContext(context.Background(), fallback) != fallback {
+		t.Fatal("fallback not used", "stack", stack.Trace().TrimRuntime())
+	}
+
+	scoped := fallback.With("request", "r-1")
+	ctx := NewContext(context.Background(), scoped)
+	if FromContext(ctx, fallback) != scoped {
+		t.Fatal("logger not carried", "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains the rendering of errors supplied as message arguments.  Errors created
+// using the kv package carry label and value pairs, including a stack, that would otherwise be
+// flattened into a single string.  These pairs are expanded into separate fields named using
+// the label of the error, for example error.stack, so that they remain searchable.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// ErrorOpts controls how errors found in message arguments are rendered
+//
+type ErrorOpts struct {
+	// StackLevel is the least severe level at which stacks are output, for example
+	// logxi.LevelError removes stacks from warnings and less severe messages.  Zero outputs
+	// stacks at every level.
+	StackLevel int
+	// StackDepth limits the number of stack frames output, zero outputs every frame
+	StackDepth int
+}
+
+// SetErrorOpts changes how errors found in message arguments are rendered
+//
+func (l *Logger) SetErrorOpts(opts ErrorOpts) {
+	l.Lock()
+	defer l.Unlock()
+	l.errorOpts = opts
+}
+
+// expandErrors returns the message arguments with any kv errors expanded into their label
+// and value pairs.  A trailing error without a label is given the label "error".  The caller
+// is expected to be holding the logger lock.
+func (l *Logger) expandErrors(level int, args []interface{}) (expanded []interface{}) {
+	hasErrors := false
+	for _, arg := range args {
+		if _, isErr := arg.(error); isErr {
+			hasErrors = true
+			break
+		}
+	}
+	if !hasErrors {
+		return args
+	}
+
+	expanded = make([]interface{}, 0, len(args)+8)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			if err, isErr := args[i].(error); isErr {
+				expanded = l.appendError(expanded, level, "error", err)
+			} else {
+				expanded = append(expanded, args[i])
+			}
+			break
+		}
+		if err, isErr := args[i+1].(error); isErr {
+			expanded = l.appendError(expanded, level, keyName(args[i]), err)
+			continue
+		}
+		expanded = append(expanded, args[i], args[i+1])
+	}
+	return expanded
+}
+
+// appendError adds an error to the message arguments, kv errors, including those wrapped by
+// other errors, have their label and value pairs added as separate arguments
+func (l *Logger) appendError(args []interface{}, level int, label string, err error) []interface{} {
+	var kvErr kv.Error
+	if !errors.As(err, &kvErr) {
+		return append(args, label, err)
+	}
+
+	text, list := kv.Parse([]byte(err.Error()))
+	args = append(args, label, string(text))
+
+	keyvals := list.Keyvals()
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		name := keyName(keyvals[i])
+		value := keyvals[i+1]
+		if name == "stack" {
+			if l.errorOpts.StackLevel != 0 && level > l.errorOpts.StackLevel {
+				continue
+			}
+			value = compactStack(fmt.Sprint(value), l.errorOpts.StackDepth)
+		}
+		args = append(args, label+"."+name, value)
+	}
+	return args
+}
+
+// compactStack converts the text of a stack, as rendered by the stack package, into a list of
+// frames.  When depth is exceeded the remaining frames are replaced with a count.
+func compactStack(text string, depth int) (frames []string) {
+	frames = strings.Fields(strings.Trim(text, "[]"))
+	if depth > 0 && len(frames) > depth {
+		frames = append(frames[:depth], fmt.Sprintf("+%d more", len(frames)-depth))
+	}
+	return frames
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+func queueError() (err kv.Error) {
+	return kv.NewError("queue missing").With("queue", "work").With("stack", stack.Trace().TrimRuntime())
+}
+
+// TestErrorExpansion checks that kv errors, including wrapped ones, are expanded into fields
+// and that stacks can be limited
+//
+func TestErrorExpansion(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewLogger("runner")
+	logger.SetOutput(out)
+	logger.SetFormat(FormatJSON)
+	logger.SetLevel(logxi.LevelInfo)
+	logger.SetErrorOpts(ErrorOpts{StackLevel: logxi.LevelError, StackDepth: 1})
+
+	err := queueError()
+	wrapped := fmt.Errorf("starting: %w", err)
+
+	_ = logger.Error("failed", "error", wrapped)
+	_ = logger.Warn("retrying", "cause", err)
+	logger.Info("plain", errors.New("not structured"))
+
+	lines := decodeLines(t, out)
+	if len(lines) != 3 {
+		t.Fatal("unexpected output", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	if lines[0]["error"] != "starting: queue missing" || lines[0]["error.queue"] != "work" {
+		t.Fatal("error not expanded", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+	frames, isList := lines[0]["error.stack"].([]interface{})
+	if !isList || len(frames) != 2 || !strings.HasPrefix(frames[0].(string), "errors_test.go:") || !strings.HasPrefix(frames[1].(string), "+") {
+		t.Fatal("stack not compacted", lines[0]["error.stack"], "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Warnings are below the stack level and so have no stack
+	if _, isPresent := lines[1]["cause.stack"]; isPresent || lines[1]["cause.queue"] != "work" {
+		t.Fatal("stack not suppressed", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Errors that are not kv errors are rendered using their text, unlabelled errors are labelled
+	if lines[2]["error"] != "not structured" {
+		t.Fatal("unexpected plain error", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains the output formats supported by the logger.  The text format is the
+// one produced by logxi and is intended for people, the JSON format writes each message as
+// a single JSON object with stable field names and is intended for log pipelines.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// Format selects the rendering used for messages written by a logger
+//
+type Format int
+
+const (
+	// FormatText renders messages using logxi, this is the default
+	FormatText Format = iota
+	// FormatJSON renders each message as a JSON object on a single line
+	FormatJSON
+)
+
+// The names of the fields present in every structured message.  Message arguments, and
+// static fields, using these names are renamed with a leading underscore.
+const (
+	FieldTime      = "ts"
+	FieldLevel     = "level"
+	FieldComponent = "component"
+	FieldMsg       = "msg"
+	FieldHost      = "host"
+
+	// FieldExtra holds the trailing value of an argument list that has an odd length
+	FieldExtra = "_extra"
+)
+
+var reservedFields = map[string]bool{
+	FieldTime:      true,
+	FieldLevel:     true,
+	FieldComponent: true,
+	FieldMsg:       true,
+	FieldHost:      true,
+}
+
+// SetFormat selects the format used for messages written by the logger, sinks are not
+// affected
+//
+func (l *Logger) SetFormat(format Format) {
+	l.Lock()
+	defer l.Unlock()
+	l.format = format
+}
+
+// SetOutput changes the destination of messages written by the logger, the current level
+// is retained
+//
+func (l *Logger) SetOutput(out io.Writer) {
+	l.Lock()
+	defer l.Unlock()
+
+	level := l.level()
+	l.out = out
+	l.log = logxi.NewLogger(logxi.NewConcurrentWriter(out), l.component)
+	l.log.SetLevel(level)
+	if l.async != nil {
+		l.async.text = nil
+	}
+}
+
+// SetStaticFields sets fields that are added to every structured message, that is messages
+// using the JSON format and those passed to sinks.  Arguments supplied with a message take
+// precedence over static fields of the same name.
+//
+func (l *Logger) SetStaticFields(fields map[string]interface{}) {
+	static := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		static[k] = v
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.static = static
+}
+
+// level returns the current threshold of the logger, the caller is expected to be holding
+// the logger lock
+func (l *Logger) level() (level int) {
+	switch {
+	case l.log.IsTrace():
+		return logxi.LevelTrace
+	case l.log.IsDebug():
+		return logxi.LevelDebug
+	case l.log.IsInfo():
+		return logxi.LevelInfo
+	case l.log.IsWarn():
+		return logxi.LevelWarn
+	}
+	return logxi.LevelError
+}
+
+// write outputs an entry using the format selected for the logger, the caller is expected
+// to be holding the logger lock
+func (l *Logger) write(entry *Entry) {
+	if l.handler != nil {
+		l.writeSlog(entry)
+		return
+	}
+	if l.format == FormatJSON {
+		line, errGo := entry.MarshalJSON()
+		if errGo != nil {
+			line = []byte(fmt.Sprintf(`{%q:%q,%q:%q}`, FieldMsg, entry.Msg, "_error", errGo.Error()))
+		}
+		_, _ = l.out.Write(append(line, '\n'))
+		return
+	}
+
+	// logxi applies the level of the logger itself, messages that are always output use a
+	// logger that accepts every level
+	text := l.log
+	if entry.always {
+		text = logxi.NewLogger(logxi.NewConcurrentWriter(l.out), l.component)
+		text.SetLevel(logxi.LevelAll)
+	}
+
+	args := make([]interface{}, 0, len(entry.Args)+2)
+	args = append(args, entry.Args...)
+	args = append(args, FieldHost, entry.Host)
+	if entry.nested {
+		text.Log(entry.Level, entry.Msg, []interface{}{args})
+		return
+	}
+	text.Log(entry.Level, entry.Msg, args)
+}
+
+// pair is a single named value within a structured message
+type pair struct {
+	key   string
+	value interface{}
+}
+
+// pairs returns the fields of an entry in the order they are output, the fixed fields
+// first followed by static fields in name order and then the message arguments
+func (e *Entry) pairs() (pairs []pair) {
+	pairs = make([]pair, 0, len(reservedFields)+len(e.Static)+len(e.Args)/2+1)
+	pairs = append(pairs,
+		pair{key: FieldTime, value: e.Time.UTC().Format(time.RFC3339Nano)},
+		pair{key: FieldLevel, value: LevelName(e.Level)},
+		pair{key: FieldComponent, value: e.Component},
+		pair{key: FieldMsg, value: e.Msg},
+		pair{key: FieldHost, value: e.Host},
+	)
+
+	index := map[string]int{}
+	add := func(key string, value interface{}) {
+		if reservedFields[key] {
+			key = "_" + key
+		}
+		if i, isPresent := index[key]; isPresent {
+			pairs[i].value = fieldValue(value)
+			return
+		}
+		index[key] = len(pairs)
+		pairs = append(pairs, pair{key: key, value: fieldValue(value)})
+	}
+
+	names := make([]string, 0, len(e.Static))
+	for name := range e.Static {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, e.Static[name])
+	}
+
+	for i := 0; i < len(e.Args); i += 2 {
+		if i+1 == len(e.Args) {
+			add(FieldExtra, e.Args[i])
+			break
+		}
+		add(keyName(e.Args[i]), e.Args[i+1])
+	}
+	return pairs
+}
+
+// keyName converts a message argument used as a key into a field name
+func keyName(key interface{}) (name string) {
+	if name, isString := key.(string); isString {
+		if len(name) == 0 {
+			return "_empty"
+		}
+		return name
+	}
+	return fmt.Sprint(key)
+}
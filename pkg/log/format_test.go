@@ -0,0 +1,103 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// TestJSONFormat checks the field names, ordering and argument handling of JSON output
+//
+func TestJSONFormat(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewLogger("runner")
+	logger.SetOutput(out)
+	logger.SetLevel(logxi.LevelInfo)
+	logger.SetFormat(FormatJSON)
+	logger.SetStaticFields(map[string]interface{}{"cluster": "test", "queue": "static"})
+
+	logger.Debug("filtered")
+	logger.Info("started", "queue", "work", 42, "answer", "msg", "shadowed", "dangling")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatal("unexpected output", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+	if !strings.HasPrefix(lines[0], `{"ts":"`) || !strings.Contains(lines[0], `"level":"INF","component":"runner","msg":"started","host":`) {
+		t.Fatal("fixed fields out of order", lines[0], "stack", stack.Trace().TrimRuntime())
+	}
+
+	fields := map[string]interface{}{}
+	if errGo := json.Unmarshal([]byte(lines[0]), &fields); errGo != nil {
+		t.Fatal(errGo.Error(), lines[0], "stack", stack.Trace().TrimRuntime())
+	}
+	for key, expected := range map[string]interface{}{
+		FieldMsg:   "started",
+		"cluster":  "test",
+		"queue":    "work",
+		"42":       "answer",
+		"_msg":     "shadowed",
+		FieldExtra: "dangling",
+	} {
+		if fields[key] != expected {
+			t.Fatal("unexpected field", key, fields[key], lines[0], "stack", stack.Trace().TrimRuntime())
+		}
+	}
+	ts, errGo := time.Parse(time.RFC3339Nano, fields[FieldTime].(string))
+	if errGo != nil || time.Since(ts) > time.Minute {
+		t.Fatal("unexpected timestamp", fields[FieldTime], "stack", stack.Trace().TrimRuntime())
+	}
+
+	// The text format is unaffected by static fields and retains the host
+	out.Reset()
+	logger.SetFormat(FormatText)
+	logger.Info("stopped", "queue", "work")
+	if text := out.String(); !strings.Contains(text, "stopped") || !strings.Contains(text, "host") || strings.Contains(text, "cluster") {
+		t.Fatal("unexpected text output", text, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestTextCompatibility pins the text output and return values of the level methods to those
+// of earlier releases, which passed their arguments to logxi as a single value
+//
+func TestTextCompatibility(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := NewLogger("compat")
+	logger.SetOutput(out)
+	logger.SetLevel(logxi.LevelInfo)
+
+	legacyOut := &bytes.Buffer{}
+	legacy := logxi.NewLogger(logxi.NewConcurrentWriter(legacyOut), "compat")
+	legacy.SetLevel(logxi.LevelInfo)
+
+	if err := logger.Warn("retrying", "queue", "work", "cause", kv.NewError("missing")); err != nil {
+		t.Fatal("warn returned an error", err, "stack", stack.Trace().TrimRuntime())
+	}
+	_ = legacy.Warn("retrying", []interface{}{"queue", "work", "cause", "missing", "host", hostName})
+
+	if err := logger.Error("failed", "attempt", 2); err == nil || err.Error() != "failed" {
+		t.Fatal("unexpected error returned", err, "stack", stack.Trace().TrimRuntime())
+	}
+	_ = legacy.Error("failed", []interface{}{"attempt", 2, "host", hostName})
+
+	// Log has always passed its arguments individually
+	logger.Log(logxi.LevelInfo, "logged", []interface{}{"queue", "work"})
+	legacy.Log(logxi.LevelInfo, "logged", []interface{}{"queue", "work", "host", hostName})
+
+	// Timestamps differ between the two loggers
+	stamp := regexp.MustCompile(`"_t":"[^"]*"`)
+	if text, expected := stamp.ReplaceAllString(out.String(), ""), stamp.ReplaceAllString(legacyOut.String(), ""); text != expected {
+		t.Fatal("text output changed", text, expected, "stack", stack.Trace().TrimRuntime())
+	}
+}
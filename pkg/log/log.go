@@ -6,8 +6,12 @@ package log // import "github.com/leaf-ai/go-service/pkg/log"
 // some common information not by default supplied by the generic code
 
 import (
+	"errors"
+	"io"
+	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	logxi "github.com/karlmutch/logxi/v1"
 )
@@ -24,7 +28,23 @@ func init() {
 // as a receiver that has the logging methods
 //
 type Logger struct {
-	log logxi.Logger
+	*shared
+	bound []interface{} // Label and value pairs added to every message by With
+}
+
+// shared holds the output and level of a logger, which are shared with its children
+type shared struct {
+	dropped   uint64 // Messages discarded by asynchronous output, first to be aligned for atomic access
+	log       logxi.Logger
+	out       io.Writer
+	format    Format
+	static    map[string]interface{} // Fields added to every structured message
+	handler   slog.Handler           // Receives messages in place of logxi when set
+	errorOpts ErrorOpts
+	component string
+	sinks     []Sink
+	sampler   *sampler // Sampling and rate limiting, nil when disabled
+	async     *async   // Queue used for asynchronous output, nil when synchronous
 	sync.Mutex
 }
 
@@ -35,7 +55,11 @@ func NewLogger(component string) (log *Logger) {
 	logxi.DisableCallstack()
 
 	return &Logger{
-		log: logxi.New(component),
+		shared: &shared{
+			log:       logxi.New(component),
+			out:       os.Stdout,
+			component: component,
+		},
 	}
 }
 
@@ -46,7 +70,11 @@ func NewErrLogger(component string) (log *Logger) {
 	logxi.DisableCallstack()
 
 	return &Logger{
-		log: logxi.NewLogger(logxi.NewConcurrentWriter(os.Stderr), component),
+		shared: &shared{
+			log:       logxi.NewLogger(logxi.NewConcurrentWriter(os.Stderr), component),
+			out:       os.Stderr,
+			component: component,
+		},
 	}
 }
 
@@ -55,13 +83,7 @@ func NewErrLogger(component string) (log *Logger) {
 // as label and then the value in a single list
 //
 func (l *Logger) Trace(msg string, args ...interface{}) {
-	allArgs := append([]interface{}{}, args...)
-	allArgs = append(allArgs, "host")
-	allArgs = append(allArgs, hostName)
-
-	l.Lock()
-	defer l.Unlock()
-	l.log.Trace(msg, allArgs)
+	l.emit(logxi.LevelTrace, msg, args)
 }
 
 // Debug is a method for output of debugging level messages
@@ -69,13 +91,7 @@ func (l *Logger) Trace(msg string, args ...interface{}) {
 // as label and then the value in a single list
 //
 func (l *Logger) Debug(msg string, args ...interface{}) {
-	allArgs := append([]interface{}{}, args...)
-	allArgs = append(allArgs, "host")
-	allArgs = append(allArgs, hostName)
-
-	l.Lock()
-	defer l.Unlock()
-	l.log.Debug(msg, allArgs)
+	l.emit(logxi.LevelDebug, msg, args)
 }
 
 // Info is a method for output of informational level messages
@@ -83,55 +99,38 @@ func (l *Logger) Debug(msg string, args ...interface{}) {
 // as label and then the value in a single list
 //
 func (l *Logger) Info(msg string, args ...interface{}) {
-	allArgs := append([]interface{}{}, args...)
-	allArgs = append(allArgs, "host")
-	allArgs = append(allArgs, hostName)
-
-	l.Lock()
-	defer l.Unlock()
-	l.log.Info(msg, allArgs)
+	l.emit(logxi.LevelInfo, msg, args)
 }
 
 // Warn is a method for output of warning level messages
 // with a varargs style list of parameters that is formatted
-// as label and then the value in a single list
+// as label and then the value in a single list.  For
+// compatibility nil is always returned.
 //
 func (l *Logger) Warn(msg string, args ...interface{}) error {
-	allArgs := append([]interface{}{}, args...)
-	allArgs = append(allArgs, "host")
-	allArgs = append(allArgs, hostName)
-
-	l.Lock()
-	defer l.Unlock()
-	return l.log.Warn(msg, allArgs)
+	l.emit(logxi.LevelWarn, msg, args)
+	return nil
 }
 
 // Error is a method for output of error level messages
 // with a varargs style list of parameters that is formatted
-// as label and then the value in a single list
+// as label and then the value in a single list.  An error
+// containing the message is returned.
 //
 func (l *Logger) Error(msg string, args ...interface{}) error {
-	allArgs := append([]interface{}{}, args...)
-	allArgs = append(allArgs, "host")
-	allArgs = append(allArgs, hostName)
-
-	l.Lock()
-	defer l.Unlock()
-	return l.log.Error(msg, allArgs)
+	l.emit(logxi.LevelError, msg, args)
+	return errors.New(msg)
 }
 
 // Fatal is a method for output of fatal level messages
 // with a varargs style list of parameters that is formatted
-// as label and then the value in a single list
+// as label and then the value in a single list, after the
+// message and any queued ahead of it are output Fatal panics
 //
 func (l *Logger) Fatal(msg string, args ...interface{}) {
-	allArgs := append([]interface{}{}, args...)
-	allArgs = append(allArgs, "host")
-	allArgs = append(allArgs, hostName)
-
-	l.Lock()
-	defer l.Unlock()
-	l.log.Fatal(msg, allArgs)
+	l.emit(logxi.LevelFatal, msg, args)
+	l.Flush()
+	panic("Exit due to fatal error: ")
 }
 
 // Log is a method for output of parameterized level messages
@@ -139,13 +138,41 @@ func (l *Logger) Fatal(msg string, args ...interface{}) {
 // as label and then the value in a single list
 //
 func (l *Logger) Log(level int, msg string, args []interface{}) {
-	allArgs := append([]interface{}{}, args...)
-	allArgs = append(allArgs, "host")
-	allArgs = append(allArgs, hostName)
+	l.emitAt(time.Now(), level, msg, args, false)
+}
 
+// emit is used by the level methods to pass a message to the sinks and then output it using
+// the format selected for the logger
+func (l *Logger) emit(level int, msg string, args []interface{}) {
+	l.emitAt(time.Now(), level, msg, args, true)
+}
+
+// emitAt is used by emit, and by callers that supply the time at which the message was
+// created.  nested selects the text output of the level methods, see Entry.
+func (l *Logger) emitAt(when time.Time, level int, msg string, args []interface{}, nested bool) {
 	l.Lock()
 	defer l.Unlock()
-	l.log.Log(level, msg, allArgs)
+
+	if !l.enabled(level) || !l.sample(level, msg, when) {
+		return
+	}
+
+	// Bound fields come first, then the arguments, with any errors expanded
+	allArgs := make([]interface{}, 0, len(l.bound)+len(args))
+	allArgs = append(allArgs, l.bound...)
+	allArgs = append(allArgs, args...)
+
+	entry := &Entry{
+		Time:      when,
+		Level:     level,
+		Component: l.component,
+		Host:      hostName,
+		Msg:       msg,
+		Args:      l.expandErrors(level, allArgs),
+		Static:    l.static,
+		nested:    nested,
+	}
+	l.deliver(entry)
 }
 
 // SetLevel can be used to set the threshold for the level of messages
@@ -157,6 +184,15 @@ func (l *Logger) SetLevel(lvl int) {
 	l.log.SetLevel(lvl)
 }
 
+// GetLevel returns the threshold for the level of messages
+// that will be output by the logger
+//
+func (l *Logger) GetLevel() (level int) {
+	l.Lock()
+	defer l.Unlock()
+	return l.level()
+}
+
 // IsTrace returns true in the event that the theshold logging level
 // allows for trace messages to appear in the output
 //
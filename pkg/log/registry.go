@@ -0,0 +1,284 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains a process wide registry of loggers by component name, allowing the
+// levels of a running server to be changed without a restart.  Levels can be set for a
+// component, set for all components, or stepped up and down, for example in response to a
+// signal.  Levels set for a component before any of its loggers are registered are applied
+// when they are.  Registration is opt-in, registered loggers are retained until they are
+// closed.
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+var registry = struct {
+	loggers map[string][]*shared // Registered loggers that have not been closed, children share an entry
+	levels  map[string]int       // Levels set for components by normalized name, applied to loggers registered later
+	sync.Mutex
+}{
+	loggers: map[string][]*shared{},
+	levels:  map[string]int{},
+}
+
+// levelNames are the names accepted by ParseLevel, and returned by LevelText
+var levelNames = map[int]string{
+	logxi.LevelTrace: "trace",
+	logxi.LevelDebug: "debug",
+	logxi.LevelInfo:  "info",
+	logxi.LevelWarn:  "warn",
+	logxi.LevelError: "error",
+	logxi.LevelFatal: "fatal",
+}
+
+// verbosity lists the levels that can be stepped through, least verbose first
+var verbosity = []int{logxi.LevelError, logxi.LevelWarn, logxi.LevelInfo, logxi.LevelDebug, logxi.LevelTrace}
+
+// NormalizeComponent returns the name under which levels for a component are stored, names
+// are matched ignoring case and treating hyphens and periods as underscores
+//
+func NormalizeComponent(component string) (normalized string) {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(component))
+}
+
+// Register adds the logger to the process wide registry so that its level follows changes
+// made for its component, and applies any level already set for the component.  The
+// registry retains the logger until it is closed, loggers created for short lived work
+// should either not be registered or be closed when done.  Child loggers share the
+// registration.  The logger is returned to allow use alongside NewLogger.
+//
+func (l *Logger) Register() (registered *Logger) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, s := range registry.loggers[l.component] {
+		if s == l.shared {
+			return l
+		}
+	}
+	registry.loggers[l.component] = append(registry.loggers[l.component], l.shared)
+	if level, isPresent := registry.levels[NormalizeComponent(l.component)]; isPresent {
+		l.SetLevel(level)
+	}
+	return l
+}
+
+// unregister removes a logger from the registry, components without loggers are forgotten
+// other than any level set for them
+func unregister(s *shared) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	loggers := registry.loggers[s.component]
+	for i, registered := range loggers {
+		if registered != s {
+			continue
+		}
+		loggers = append(loggers[:i:i], loggers[i+1:]...)
+		if len(loggers) == 0 {
+			delete(registry.loggers, s.component)
+		} else {
+			registry.loggers[s.component] = loggers
+		}
+		return
+	}
+}
+
+// ParseLevel converts the name of a level, for example "debug" or "DBG", or its number into
+// a logxi level
+//
+func ParseLevel(text string) (level int, err kv.Error) {
+	text = strings.TrimSpace(text)
+	for level, name := range levelNames {
+		if strings.EqualFold(text, name) || strings.EqualFold(text, LevelName(level)) {
+			return level, nil
+		}
+	}
+	if level, errGo := strconv.Atoi(text); errGo == nil {
+		if _, isPresent := levelNames[level]; isPresent {
+			return level, nil
+		}
+	}
+	return 0, kv.NewError("unknown log level").With("level", text).With("stack", stack.Trace().TrimRuntime())
+}
+
+// LevelText returns the lower case name of a level, for example "debug"
+//
+func LevelText(level int) (name string) {
+	if name, isPresent := levelNames[level]; isPresent {
+		return name
+	}
+	return strconv.Itoa(level)
+}
+
+// Components returns the sorted names of the components that have registered loggers
+//
+func Components() (components []string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	components = make([]string, 0, len(registry.loggers))
+	for component := range registry.loggers {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	return components
+}
+
+// ComponentLevels returns the current level of each component that has registered loggers
+//
+func ComponentLevels() (levels map[string]int) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	levels = make(map[string]int, len(registry.loggers))
+	for component, loggers := range registry.loggers {
+		levels[component] = (&Logger{shared: loggers[0]}).GetLevel()
+	}
+	return levels
+}
+
+// SetComponentLevel sets the level of every registered logger for a component, including
+// those registered later.  Loggers registered later are matched using NormalizeComponent.
+// The change is logged by each logger whose level changed.  False is returned when the
+// component has no registered loggers yet.
+//
+func SetComponentLevel(component string, level int) (known bool) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.levels[NormalizeComponent(component)] = level
+	return setLevel(component, level)
+}
+
+// SetAllLevels sets the level of every registered logger, unlike SetComponentLevel loggers
+// registered later are not affected
+//
+func SetAllLevels(level int) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for component := range registry.loggers {
+		setLevel(component, level)
+	}
+}
+
+// StepLevels makes every registered logger more verbose by the supplied number of levels,
+// negative steps make them less verbose.  Levels are kept between error and trace.
+//
+func StepLevels(steps int) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, loggers := range registry.loggers {
+		for _, s := range loggers {
+			l := &Logger{shared: s}
+
+			// Find the position of the current level, levels more verbose than trace are
+			// treated as trace
+			current := l.GetLevel()
+			i := len(verbosity) - 1
+			for j, level := range verbosity {
+				if level >= current {
+					i = j
+					break
+				}
+			}
+			i += steps
+			if i < 0 {
+				i = 0
+			}
+			if i >= len(verbosity) {
+				i = len(verbosity) - 1
+			}
+			l.changeLevel(verbosity[i])
+		}
+	}
+}
+
+// setLevel changes the level of the loggers for a component, the caller is expected to be
+// holding the registry lock
+func setLevel(component string, level int) (known bool) {
+	loggers := registry.loggers[component]
+	for _, s := range loggers {
+		(&Logger{shared: s}).changeLevel(level)
+	}
+	return len(loggers) != 0
+}
+
+// changeLevel sets the level of a logger and, if the level changed, logs the change at Info
+// even when the new level would suppress it
+func (l *Logger) changeLevel(level int) {
+	l.Lock()
+	defer l.Unlock()
+
+	old := l.level()
+	l.log.SetLevel(level)
+	if old == level {
+		return
+	}
+
+	entry := &Entry{
+		Time:      time.Now(),
+		Level:     logxi.LevelInfo,
+		Component: l.component,
+		Host:      hostName,
+		Msg:       "log level changed",
+		Args:      []interface{}{"from", LevelText(old), "to", LevelText(level)},
+		Static:    l.static,
+		always:    true,
+	}
+	l.deliver(entry)
+}
+
+// LevelHandler returns a handler for administering levels.  GET returns the level of each
+// component as JSON.  PUT or POST using the level query parameter changes the level of the
+// component named using the component query parameter, or of all components when it is absent.
+//
+func LevelHandler() (handler http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			level, err := ParseLevel(r.URL.Query().Get("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if component := r.URL.Query().Get("component"); len(component) != 0 {
+				if _, isPresent := ComponentLevels()[component]; !isPresent {
+					http.Error(w, "unknown component "+component, http.StatusNotFound)
+					return
+				}
+				SetComponentLevel(component, level)
+			} else {
+				SetAllLevels(level)
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		levels := map[string]string{}
+		for component, level := range ComponentLevels() {
+			levels[component] = LevelText(level)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(levels)
+	}
+}
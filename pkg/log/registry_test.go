@@ -0,0 +1,154 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-stack/stack"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// TestParseLevel checks the level names and numbers accepted
+//
+func TestParseLevel(t *testing.T) {
+	for text, expected := range map[string]int{
+		"debug": logxi.LevelDebug,
+		"WRN":   logxi.LevelWarn,
+		" Info": logxi.LevelInfo,
+		"10":    logxi.LevelTrace,
+	} {
+		if level, err := ParseLevel(text); err != nil || level != expected {
+			t.Fatal("unexpected level", text, level, err, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatal("unknown level accepted", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestComponentLevels checks that levels can be changed by component, including for loggers
+// created later, and that changes are logged by each logger at the info level
+//
+func TestComponentLevels(t *testing.T) {
+	// Levels set for components are retained by the registry, remove them so that the test
+	// can be repeated
+	defer func() {
+		registry.Lock()
+		defer registry.Unlock()
+		delete(registry.levels, NormalizeComponent("registry-first"))
+		delete(registry.levels, NormalizeComponent("registry-later"))
+	}()
+
+	out := &bytes.Buffer{}
+	first := NewLogger("registry-first").Register()
+	defer first.Close()
+	first.SetOutput(out)
+	first.SetFormat(FormatJSON)
+	first.SetLevel(logxi.LevelWarn)
+
+	secondOut := &bytes.Buffer{}
+	second := NewLogger("registry-first").Register()
+	defer second.Close()
+	second.SetOutput(secondOut)
+	second.SetFormat(FormatJSON)
+	second.SetLevel(logxi.LevelDebug)
+
+	// Loggers are only affected once registered
+	unregistered := NewLogger("registry-first")
+	unregistered.SetLevel(logxi.LevelWarn)
+
+	if !SetComponentLevel("registry-first", logxi.LevelDebug) {
+		t.Fatal("component not known", "stack", stack.Trace().TrimRuntime())
+	}
+	if first.GetLevel() != logxi.LevelDebug || second.GetLevel() != logxi.LevelDebug {
+		t.Fatal("level not applied", first.GetLevel(), second.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+	if unregistered.GetLevel() != logxi.LevelWarn {
+		t.Fatal("level applied to unregistered logger", unregistered.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+	if line := out.String(); !strings.Contains(line, `"level":"INF","component":"registry-first","msg":"log level changed","host":`) || !strings.Contains(line, `"from":"warn","to":"debug"`) {
+		t.Fatal("level change not logged", line, "stack", stack.Trace().TrimRuntime())
+	}
+	// Loggers already at the level have nothing to announce
+	if secondOut.Len() != 0 {
+		t.Fatal("unchanged level logged", secondOut.String(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Changes to levels that suppress info messages are still logged, at info
+	out.Reset()
+	SetComponentLevel("registry-first", logxi.LevelError)
+	for _, line := range []string{out.String(), secondOut.String()} {
+		if !strings.Contains(line, `"level":"INF"`) || !strings.Contains(line, `"from":"debug","to":"error"`) {
+			t.Fatal("level change not logged", line, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	if SetComponentLevel("registry-later", logxi.LevelTrace) {
+		t.Fatal("unknown component reported as known", "stack", stack.Trace().TrimRuntime())
+	}
+	later := NewLogger("Registry.Later").Register()
+	defer later.Close()
+	if later.GetLevel() != logxi.LevelTrace {
+		t.Fatal("level not applied to new logger", later.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	first.SetLevel(logxi.LevelDebug)
+	StepLevels(-1)
+	if first.GetLevel() != logxi.LevelInfo || second.GetLevel() != logxi.LevelError {
+		t.Fatal("level not stepped", first.GetLevel(), second.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+	StepLevels(-10)
+	if first.GetLevel() != logxi.LevelError {
+		t.Fatal("level not clamped", first.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Closed loggers are removed from the registry
+	first.Close()
+	second.Close()
+	if _, isPresent := ComponentLevels()["registry-first"]; isPresent {
+		t.Fatal("closed loggers retained", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestLevelHandler checks that levels can be read and changed over HTTP
+//
+func TestLevelHandler(t *testing.T) {
+	logger := NewLogger("registry-http").Register()
+	defer logger.Close()
+	logger.SetLevel(logxi.LevelError)
+
+	server := httptest.NewServer(LevelHandler())
+	defer server.Close()
+
+	resp, errGo := http.Post(server.URL+"?component=registry-http&level=trace", "", nil)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	levels := map[string]string{}
+	errGo = json.NewDecoder(resp.Body).Decode(&levels)
+	resp.Body.Close()
+	if errGo != nil || resp.StatusCode != http.StatusOK || levels["registry-http"] != "trace" || !logger.IsTrace() {
+		t.Fatal("level not changed", resp.StatusCode, levels, errGo, "stack", stack.Trace().TrimRuntime())
+	}
+
+	for query, expected := range map[string]int{
+		"?component=registry-http&level=loud":    http.StatusBadRequest,
+		"?component=registry-missing&level=info": http.StatusNotFound,
+	} {
+		resp, errGo := http.Post(server.URL+query, "", nil)
+		if errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatal("unexpected status", query, resp.StatusCode, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
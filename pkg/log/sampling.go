@@ -0,0 +1,174 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains sampling and rate limiting of messages.  When a dependency fails a server
+// can emit the same message thousands of times a second, which adds little information while
+// slowing the server and flooding log pipelines.
+//
+// Sampling counts messages by level and text within an interval, outputting the first few and
+// then every Nth.  Rate limiting applies a token bucket across all messages of a logger and
+// its children, each logger created by NewLogger has its own bucket.  Messages that are
+// suppressed are counted and reported periodically using a summary message, which is output
+// whatever the level of the logger.  Fatal messages are never suppressed.
+
+import (
+	"time"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// SamplingOpts contains the parameters used to sample and rate limit messages
+//
+type SamplingOpts struct {
+	// Interval is the period over which messages with the same level and text are counted,
+	// zero disables sampling
+	Interval time.Duration
+	// First is the number of messages output in each interval before sampling begins
+	First int
+	// Thereafter causes every Thereafter'th message to be output once sampling has begun,
+	// zero suppresses all of them
+	Thereafter int
+
+	// Rate is the number of messages per second allowed across all messages of the logger and
+	// its children, zero disables rate limiting
+	Rate float64
+	// Burst is the number of messages that can be output at once, defaults to Rate
+	Burst int
+
+	// SummaryInterval is the delay between a message first being suppressed and a summary
+	// being output, defaults to one minute
+	SummaryInterval time.Duration
+}
+
+type sampleKey struct {
+	level int
+	msg   string
+}
+
+// sampler holds the sampling and rate limiting state of a logger
+type sampler struct {
+	opts SamplingOpts
+
+	counts      map[sampleKey]int
+	windowStart time.Time
+
+	tokens     float64
+	lastRefill time.Time
+
+	sampled uint64 // Messages suppressed by sampling since the last summary
+	limited uint64 // Messages suppressed by the rate limit since the last summary
+	summary *time.Timer
+}
+
+// SetSampling enables sampling and rate limiting of messages, a zero SamplingOpts disables
+// them.  The settings are shared with child loggers.
+//
+func (l *Logger) SetSampling(opts SamplingOpts) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.sampler != nil && l.sampler.summary != nil {
+		l.sampler.summary.Stop()
+	}
+	if opts.Interval <= 0 && opts.Rate <= 0 {
+		l.sampler = nil
+		return
+	}
+
+	if opts.Burst <= 0 {
+		opts.Burst = int(opts.Rate)
+		if opts.Burst < 1 {
+			opts.Burst = 1
+		}
+	}
+	if opts.SummaryInterval <= 0 {
+		opts.SummaryInterval = time.Minute
+	}
+	now := time.Now()
+	l.sampler = &sampler{
+		opts:        opts,
+		counts:      map[sampleKey]int{},
+		windowStart: now,
+		tokens:      float64(opts.Burst),
+		lastRefill:  now,
+	}
+}
+
+// sample tests if a message should be output, counting those that are not, the caller is
+// expected to be holding the logger lock
+func (l *Logger) sample(level int, msg string, now time.Time) (output bool) {
+	s := l.sampler
+	if s == nil || level <= logxi.LevelFatal {
+		return true
+	}
+
+	if s.opts.Interval > 0 {
+		if now.Sub(s.windowStart) >= s.opts.Interval {
+			s.counts = map[sampleKey]int{}
+			s.windowStart = now
+		}
+		key := sampleKey{level: level, msg: msg}
+		s.counts[key]++
+		if n := s.counts[key]; n > s.opts.First {
+			if s.opts.Thereafter <= 0 || (n-s.opts.First)%s.opts.Thereafter != 0 {
+				s.sampled++
+				l.scheduleSummary()
+				return false
+			}
+		}
+	}
+
+	if s.opts.Rate > 0 {
+		s.tokens += now.Sub(s.lastRefill).Seconds() * s.opts.Rate
+		if s.tokens > float64(s.opts.Burst) {
+			s.tokens = float64(s.opts.Burst)
+		}
+		s.lastRefill = now
+		if s.tokens < 1 {
+			s.limited++
+			l.scheduleSummary()
+			return false
+		}
+		s.tokens--
+	}
+	return true
+}
+
+// scheduleSummary arranges for a summary of suppressed messages to be output, the caller is
+// expected to be holding the logger lock
+func (l *Logger) scheduleSummary() {
+	s := l.sampler
+	if s.summary != nil {
+		return
+	}
+	s.summary = time.AfterFunc(s.opts.SummaryInterval, func() {
+		l.Lock()
+		defer l.Unlock()
+
+		// Sampling may have been changed while waiting
+		if l.sampler != s {
+			return
+		}
+		s.summary = nil
+		if s.sampled == 0 && s.limited == 0 {
+			return
+		}
+
+		// The summary is output regardless of level, the messages it counts were not
+		entry := &Entry{
+			Time:      time.Now(),
+			Level:     logxi.LevelWarn,
+			Component: l.component,
+			Host:      hostName,
+			Msg:       "log messages suppressed",
+			Args:      []interface{}{"sampled", s.sampled, "rate_limited", s.limited, "period", s.opts.SummaryInterval.String()},
+			Static:    l.static,
+			always:    true,
+		}
+		s.sampled = 0
+		s.limited = 0
+
+		l.deliver(entry)
+	})
+}
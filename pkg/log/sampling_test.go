@@ -0,0 +1,152 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// waitSummary waits for a logger to output the summary of suppressed messages, the output is
+// read while holding the logger lock as the summary is written from a timer
+func waitSummary(t *testing.T, logger *Logger, out *bytes.Buffer) (lines []map[string]interface{}) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logger.Lock()
+		lines = decodeLines(t, bytes.NewBufferString(out.String()))
+		logger.Unlock()
+
+		if len(lines) != 0 && lines[len(lines)-1]["msg"] == "log messages suppressed" {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("summary not output", lines, "stack", stack.Trace().TrimRuntime())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSampling checks that repeated messages are sampled by level and text, and that the
+// number suppressed is reported
+//
+func TestSampling(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewLogger("sampling")
+	logger.SetOutput(out)
+	logger.SetLevel(logxi.LevelInfo)
+	logger.SetFormat(FormatJSON)
+	logger.SetSampling(SamplingOpts{
+		Interval:        time.Minute,
+		First:           2,
+		Thereafter:      3,
+		SummaryInterval: 50 * time.Millisecond,
+	})
+
+	for i := 1; i <= 10; i++ {
+		logger.Info("retrying", "attempt", i)
+	}
+	logger.Warn("retrying")
+
+	lines := waitSummary(t, logger, out)
+	if len(lines) != 6 {
+		t.Fatal("unexpected output", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+	for i, attempt := range []float64{1, 2, 5, 8} {
+		if lines[i]["attempt"] != attempt {
+			t.Fatal("unexpected message sampled", i, lines[i], "stack", stack.Trace().TrimRuntime())
+		}
+	}
+	if lines[4]["level"] != "WRN" {
+		t.Fatal("level not part of the sampling key", lines[4], "stack", stack.Trace().TrimRuntime())
+	}
+	if summary := lines[5]; summary["sampled"] != float64(6) || summary["rate_limited"] != float64(0) {
+		t.Fatal("unexpected summary", summary, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestRateLimit checks that messages beyond the rate limit are suppressed and reported
+//
+func TestRateLimit(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewLogger("sampling-rate")
+	logger.SetOutput(out)
+	logger.SetLevel(logxi.LevelInfo)
+	logger.SetFormat(FormatJSON)
+	logger.SetSampling(SamplingOpts{
+		Rate:            0.1,
+		Burst:           2,
+		SummaryInterval: 50 * time.Millisecond,
+	})
+
+	for i := 0; i != 5; i++ {
+		logger.Info(fmt.Sprint("message ", i))
+	}
+
+	lines := waitSummary(t, logger, out)
+	if len(lines) != 3 || lines[0]["msg"] != "message 0" || lines[1]["msg"] != "message 1" {
+		t.Fatal("unexpected output", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+	if summary := lines[2]; summary["rate_limited"] != float64(3) || summary["sampled"] != float64(0) {
+		t.Fatal("unexpected summary", summary, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Disabling sampling allows all messages through
+	logger.SetSampling(SamplingOpts{})
+	logger.Info("message 5")
+	logger.Info("message 6")
+	if lines := decodeLines(t, out); len(lines) != 5 {
+		t.Fatal("messages suppressed after sampling was disabled", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestSamplingErrorLevel checks that the summary is output by loggers whose level would
+// otherwise suppress it, using the text format
+//
+func TestSamplingErrorLevel(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewLogger("sampling-error")
+	logger.SetOutput(out)
+	logger.SetLevel(logxi.LevelError)
+	logger.SetSampling(SamplingOpts{
+		Interval:        time.Minute,
+		First:           1,
+		SummaryInterval: 50 * time.Millisecond,
+	})
+
+	for i := 0; i != 5; i++ {
+		_ = logger.Error("connection refused")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logger.Lock()
+		text := out.String()
+		logger.Unlock()
+
+		if strings.Contains(text, "log messages suppressed") {
+			if strings.Count(text, "connection refused") != 1 || !strings.Contains(text, `"sampled":4`) {
+				t.Fatal("unexpected output", text, "stack", stack.Trace().TrimRuntime())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("summary not output", text, "stack", stack.Trace().TrimRuntime())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The level of the logger is unchanged
+	if logger.GetLevel() != logxi.LevelError || logger.IsWarn() {
+		t.Fatal("level changed", logger.GetLevel(), "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+//go:build !windows
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleLevelSignals steps the level of every registered logger when signals are received
+// until the context is cancelled.  SIGUSR1 makes logging more verbose by one level, SIGUSR2
+// makes it less verbose.
+//
+func HandleLevelSignals(ctx context.Context) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(sigC)
+		for {
+			select {
+			case sig := <-sigC:
+				if sig == syscall.SIGUSR1 {
+					StepLevels(1)
+				} else {
+					StepLevels(-1)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
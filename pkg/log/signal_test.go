@@ -0,0 +1,49 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+//go:build !windows
+
+package log
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+func waitLevel(ctx context.Context, t *testing.T, logger *Logger, level int) {
+	for logger.GetLevel() != level {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("level not reached", level, logger.GetLevel(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestLevelSignals checks that SIGUSR1 and SIGUSR2 step levels up and down
+//
+func TestLevelSignals(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	logger := NewLogger("registry-signals").Register()
+	defer logger.Close()
+	logger.SetLevel(logxi.LevelInfo)
+
+	HandleLevelSignals(ctx)
+
+	if errGo := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	waitLevel(ctx, t, logger, logxi.LevelDebug)
+
+	if errGo := syscall.Kill(syscall.Getpid(), syscall.SIGUSR2); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	waitLevel(ctx, t, logger, logxi.LevelInfo)
+}
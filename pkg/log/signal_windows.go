@@ -0,0 +1,13 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+import (
+	"context"
+)
+
+// HandleLevelSignals does nothing on Windows which has no user defined signals, levels can
+// be changed using the LevelHandler instead
+//
+func HandleLevelSignals(ctx context.Context) {
+}
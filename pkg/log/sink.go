@@ -0,0 +1,156 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains the definition of sinks, which receive a copy of every message
+// emitted by a logger so that they can be shipped to destinations other than the
+// console, for example a hosted log service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// Entry is a single log message as presented to a Sink
+//
+type Entry struct {
+	Time      time.Time
+	Level     int
+	Component string
+	Host      string
+	Msg       string
+	// Args contains the label and value pairs supplied with the message
+	Args []interface{}
+	// Static contains the fields configured for the logger using SetStaticFields
+	Static map[string]interface{}
+
+	// nested is set for messages from the level methods, such as Info, which have always
+	// passed their arguments to logxi as a single value.  Their text output is kept the same
+	// for existing users.
+	nested bool
+	// always is set for messages about the logger itself, such as summaries of suppressed
+	// messages, which are output regardless of the level of the logger
+	always bool
+}
+
+// Sink is implemented by destinations that receive log entries in addition to the
+// console.  Entries are shared with other sinks and must not be modified.
+//
+// For a synchronous logger Write is called by the goroutine logging the message while
+// the logger is locked, before the logging method returns.
+//
+// For an asynchronous logger Write is called by the goroutine writing the queue, after
+// the logging method has returned and without the logger being locked.  Calls for one
+// logger are made in order and never overlap, but can run at the same time as any method
+// of the logger, including Flush and Close.  Flush and Close return once the calls for the
+// messages they wait on have returned.  Close waits while holding the logger lock.
+//
+// In either mode implementations must not block, or log using the same logger.  A sink
+// added to several loggers can receive calls from each of them concurrently.
+//
+type Sink interface {
+	Write(entry *Entry)
+}
+
+// LevelName returns the short name logxi uses for a level, for example "INF"
+//
+func LevelName(level int) (name string) {
+	if name, isPresent := logxi.LevelMap[level]; isPresent {
+		return name
+	}
+	return fmt.Sprint(level)
+}
+
+// Fields returns the contents of the entry as a map suitable for use with structured
+// output formats such as JSON.  Values that cannot be encoded as JSON are converted to
+// their string representation.
+//
+func (e *Entry) Fields() (fields map[string]interface{}) {
+	pairs := e.pairs()
+	fields = make(map[string]interface{}, len(pairs))
+	for _, p := range pairs {
+		fields[p.key] = p.value
+	}
+	return fields
+}
+
+func fieldValue(value interface{}) (result interface{}) {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+	if _, errGo := json.Marshal(value); errGo != nil {
+		return fmt.Sprint(value)
+	}
+	return value
+}
+
+// MarshalJSON renders the entry as a single JSON object, see Fields
+//
+func (e *Entry) MarshalJSON() (result []byte, errGo error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, p := range e.pairs() {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, errGo := json.Marshal(p.key)
+		if errGo != nil {
+			return nil, errGo
+		}
+		value, errGo := json.Marshal(p.value)
+		if errGo != nil {
+			return nil, errGo
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// AddSink attaches a sink to the logger.  Messages passed to the sink are those that
+// the logger's current level allows to be output.
+//
+func (l *Logger) AddSink(sink Sink) {
+	l.Lock()
+	defer l.Unlock()
+	l.sinks = append(l.sinks, sink)
+}
+
+// enabled is used to test whether a message at the supplied level will be output,
+// the caller is expected to be holding the logger lock
+func (l *Logger) enabled(level int) bool {
+	if l.handler != nil && !l.handler.Enabled(context.Background(), slogLevel(level)) {
+		return false
+	}
+	switch {
+	case level >= logxi.LevelTrace:
+		return l.log.IsTrace()
+	case level >= logxi.LevelDebug:
+		return l.log.IsDebug()
+	case level >= logxi.LevelInfo:
+		return l.log.IsInfo()
+	case level >= logxi.LevelWarn:
+		return l.log.IsWarn()
+	}
+	return true
+}
+
+// dispatch passes a message to any sinks attached to the logger, the caller is expected
+// to be holding the logger lock
+func (l *Logger) dispatch(entry *Entry) {
+	for _, sink := range l.sinks {
+		sink.Write(entry)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log // import "github.com/leaf-ai/go-service/pkg/log"
+
+// This file contains a bridge between the logger and the standard library log/slog package
+// so that both can be used while code is migrated.  SlogHandler allows slog to write through
+// a Logger, and NewSlogLogger allows code that expects a Logger to write to an slog.Handler.
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// slogLevel maps a logxi level to the nearest slog level
+func slogLevel(level int) (slevel slog.Level) {
+	switch {
+	case level >= logxi.LevelTrace:
+		return slog.LevelDebug - 4
+	case level >= logxi.LevelDebug:
+		return slog.LevelDebug
+	case level >= logxi.LevelInfo:
+		return slog.LevelInfo
+	case level >= logxi.LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// logxiLevel maps an slog level to the nearest logxi level, levels above error are logged
+// as errors and not as fatal messages
+func logxiLevel(slevel slog.Level) (level int) {
+	switch {
+	case slevel >= slog.LevelError:
+		return logxi.LevelError
+	case slevel >= slog.LevelWarn:
+		return logxi.LevelWarn
+	case slevel >= slog.LevelInfo:
+		return logxi.LevelInfo
+	case slevel >= slog.LevelDebug:
+		return logxi.LevelDebug
+	}
+	return logxi.LevelTrace
+}
+
+// SlogHandler is an slog.Handler that writes records using a Logger.  Attributes within
+// groups are named using the group names separated by periods.
+//
+type SlogHandler struct {
+	logger *Logger
+	prefix string        // The open groups, each followed by a period
+	attrs  []interface{} // Label and value pairs added using WithAttrs
+}
+
+// NewSlogHandler creates an slog.Handler that writes using the supplied logger
+//
+func NewSlogHandler(logger *Logger) (handler *SlogHandler) {
+	return &SlogHandler{logger: logger}
+}
+
+// Slog returns an slog.Logger that writes using the logger
+//
+func (l *Logger) Slog() (logger *slog.Logger) {
+	return slog.New(NewSlogHandler(l))
+}
+
+// Enabled tests if the logger will output records at the supplied level
+//
+func (h *SlogHandler) Enabled(ctx context.Context, slevel slog.Level) (enabled bool) {
+	h.logger.Lock()
+	defer h.logger.Unlock()
+	return h.logger.enabled(logxiLevel(slevel))
+}
+
+// Handle writes a record using the logger
+//
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) (errGo error) {
+	args := make([]interface{}, 0, len(h.attrs)+2*record.NumAttrs())
+	args = append(args, h.attrs...)
+	record.Attrs(func(attr slog.Attr) bool {
+		args = appendAttr(args, h.prefix, attr)
+		return true
+	})
+
+	when := record.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+	h.logger.emitAt(when, logxiLevel(record.Level), record.Message, args, false)
+	return nil
+}
+
+// WithAttrs returns a handler that adds the attributes to every record
+//
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) (handler slog.Handler) {
+	child := &SlogHandler{
+		logger: h.logger,
+		prefix: h.prefix,
+		attrs:  append([]interface{}{}, h.attrs...),
+	}
+	for _, attr := range attrs {
+		child.attrs = appendAttr(child.attrs, h.prefix, attr)
+	}
+	return child
+}
+
+// WithGroup returns a handler that places subsequent attributes within a group
+//
+func (h *SlogHandler) WithGroup(name string) (handler slog.Handler) {
+	if len(name) == 0 {
+		return h
+	}
+	return &SlogHandler{
+		logger: h.logger,
+		prefix: h.prefix + name + ".",
+		attrs:  h.attrs,
+	}
+}
+
+// appendAttr adds an attribute to a list of label and value pairs, expanding groups
+func appendAttr(args []interface{}, prefix string, attr slog.Attr) []interface{} {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return args
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		// Groups without a name have their attributes inlined
+		if len(attr.Key) != 0 {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			args = appendAttr(args, prefix, member)
+		}
+		return args
+	}
+	return append(args, prefix+attr.Key, attr.Value.Any())
+}
+
+// NewSlogLogger creates a Logger that writes messages to an slog.Handler, allowing code
+// that uses a Logger to share the output of code using slog.  The handler decides which
+// levels are output, SetLevel can be used to restrict these further.
+//
+func NewSlogLogger(handler slog.Handler, component string) (log *Logger) {
+	logxi.DisableCallstack()
+
+	l := &Logger{
+		shared: &shared{
+			log:       logxi.NewLogger(logxi.NewConcurrentWriter(os.Stderr), component),
+			out:       os.Stderr,
+			handler:   handler,
+			component: component,
+		},
+	}
+	l.log.SetLevel(logxi.LevelAll)
+	return l
+}
+
+// writeSlog outputs an entry using the slog handler of the logger, the caller is expected
+// to be holding the logger lock
+func (l *Logger) writeSlog(entry *Entry) {
+	record := slog.NewRecord(entry.Time, slogLevel(entry.Level), entry.Msg, 0)
+	for _, p := range entry.pairs() {
+		switch p.key {
+		case FieldTime, FieldLevel, FieldMsg:
+			continue
+		}
+		record.AddAttrs(slog.Any(p.key, p.value))
+	}
+	_ = l.handler.Handle(context.Background(), record)
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/go-stack/stack"
+
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+func decodeLines(t *testing.T, out *bytes.Buffer) (lines []map[string]interface{}) {
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		fields := map[string]interface{}{}
+		if errGo := json.Unmarshal([]byte(line), &fields); errGo != nil {
+			t.Fatal(errGo.Error(), line, "stack", stack.Trace().TrimRuntime())
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+// TestSlogHandler checks that slog records are written through a Logger
+//
+func TestSlogHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewLogger("runner")
+	logger.SetOutput(out)
+	logger.SetLevel(logxi.LevelInfo)
+	logger.SetFormat(FormatJSON)
+
+	slogger := logger.Slog().With("queue", "work").WithGroup("request")
+	slogger.Debug("filtered")
+	slogger.Warn("slow", "id", 7, slog.Group("timing", "ms", 250), slog.Group("", "inline", true))
+
+	lines := decodeLines(t, out)
+	if len(lines) != 1 {
+		t.Fatal("unexpected output", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+	for key, expected := range map[string]interface{}{
+		FieldMsg:            "slow",
+		FieldLevel:          "WRN",
+		FieldComponent:      "runner",
+		FieldHost:           hostName,
+		"queue":             "work",
+		"request.id":        float64(7),
+		"request.timing.ms": float64(250),
+		"request.inline":    true,
+	} {
+		if lines[0][key] != expected {
+			t.Fatal("unexpected field", key, lines[0][key], out.String(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestSlogLogger checks that a Logger can write to an slog.Handler
+//
+func TestSlogLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	logger := NewSlogLogger(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}), "runner")
+	logger.Trace("filtered")
+	logger.Debug("started", "queue", "work")
+	_ = logger.Error("failed", "attempt", 2)
+
+	lines := decodeLines(t, out)
+	if len(lines) != 2 {
+		t.Fatal("unexpected output", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+	if lines[0]["msg"] != "started" || lines[0]["level"] != "DEBUG" || lines[0]["queue"] != "work" || lines[0][FieldComponent] != "runner" || lines[0][FieldHost] != hostName {
+		t.Fatal("unexpected record", lines[0], "stack", stack.Trace().TrimRuntime())
+	}
+	if lines[1]["level"] != "ERROR" || lines[1]["attempt"] != float64(2) {
+		t.Fatal("unexpected record", lines[1], "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Levels can be restricted beyond those of the handler
+	logger.SetLevel(logxi.LevelWarn)
+	out.Reset()
+	logger.Info("filtered")
+	if out.Len() != 0 {
+		t.Fatal("level not applied", out.String(), "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package fakes3 // import "github.com/leaf-ai/go-service/pkg/objstore/fakes3"
+
+// This file contains the implementation of an in-process fake of the S3 REST API.  It
+// supports the subset of operations used by the objstore package using path style addressing
+// and is intended for use within unit tests, it performs no authentication.
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+type object struct {
+	data         []byte
+	etag         string
+	contentType  string
+	meta         http.Header
+	lastModified time.Time
+}
+
+type upload struct {
+	bucket string
+	key    string
+	parts  map[int][]byte
+	object
+}
+
+// Server is an in-process fake S3 server
+//
+type Server struct {
+	*httptest.Server
+
+	buckets  map[string]map[string]*object
+	uploads  map[string]*upload
+	failures int
+	requests int
+
+	sync.Mutex
+}
+
+// New starts a fake S3 server listening on the loopback interface, the server should be
+// closed by the caller when it is no longer needed
+//
+func New() (s *Server) {
+	s = &Server{
+		buckets: map[string]map[string]*object{},
+		uploads: map[string]*upload{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// CreateBucket adds an empty bucket to the server
+//
+func (s *Server) CreateBucket(bucket string) {
+	s.Lock()
+	defer s.Unlock()
+	if _, isPresent := s.buckets[bucket]; !isPresent {
+		s.buckets[bucket] = map[string]*object{}
+	}
+}
+
+// FailNext will cause the next count requests to be failed with an internal server error,
+// useful for testing retries
+//
+func (s *Server) FailNext(count int) {
+	s.Lock()
+	defer s.Unlock()
+	s.failures = count
+}
+
+// Requests returns the number of requests the server has received
+//
+func (s *Server) Requests() (count int) {
+	s.Lock()
+	defer s.Unlock()
+	return s.requests
+}
+
+// Uploads returns the number of multipart uploads that have been started and not yet
+// completed or aborted
+//
+func (s *Server) Uploads() (count int) {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.uploads)
+}
+
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = xml.NewEncoder(w).Encode(s3Error{Code: code, Message: msg, Resource: r.URL.Path, RequestID: xid.New().String()})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func metaOf(r *http.Request) (meta http.Header) {
+	meta = http.Header{}
+	for k, v := range r.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.requests++
+	if s.failures > 0 {
+		s.failures--
+		_, _ = io.Copy(io.Discard, r.Body)
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "injected failure")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	bucket := parts[0]
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+
+	if len(key) == 0 {
+		switch r.Method {
+		case http.MethodPut:
+			if _, isPresent := s.buckets[bucket]; !isPresent {
+				s.buckets[bucket] = map[string]*object{}
+			}
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			s.list(w, r, bucket, query)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+		}
+		return
+	}
+
+	objects, isPresent := s.buckets[bucket]
+	if !isPresent {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		switch {
+		case len(query.Get("uploadId")) != 0:
+			s.uploadPart(w, r, query)
+		case len(r.Header.Get("X-Amz-Copy-Source")) != 0:
+			s.copyObject(w, r, objects, key)
+		default:
+			data, errGo := io.ReadAll(r.Body)
+			if errGo != nil {
+				writeError(w, r, http.StatusBadRequest, "IncompleteBody", errGo.Error())
+				return
+			}
+			obj := &object{
+				data:         data,
+				etag:         etagOf(data),
+				contentType:  r.Header.Get("Content-Type"),
+				meta:         metaOf(r),
+				lastModified: time.Now().UTC(),
+			}
+			objects[key] = obj
+			w.Header().Set("ETag", obj.etag)
+			w.WriteHeader(http.StatusOK)
+		}
+	case http.MethodPost:
+		_, isUploads := query["uploads"]
+		switch {
+		case isUploads:
+			id := xid.New().String()
+			s.uploads[id] = &upload{
+				bucket: bucket,
+				key:    key,
+				parts:  map[int][]byte{},
+				object: object{
+					contentType: r.Header.Get("Content-Type"),
+					meta:        metaOf(r),
+				},
+			}
+			writeXML(w, struct {
+				XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+				Bucket   string   `xml:"Bucket"`
+				Key      string   `xml:"Key"`
+				UploadID string   `xml:"UploadId"`
+			}{Bucket: bucket, Key: key, UploadID: id})
+		case len(query.Get("uploadId")) != 0:
+			s.completeUpload(w, r, objects, bucket, key, query.Get("uploadId"))
+		default:
+			writeError(w, r, http.StatusBadRequest, "InvalidRequest", "unsupported POST")
+		}
+	case http.MethodGet, http.MethodHead:
+		obj, isPresent := objects[key]
+		if !isPresent {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		for k, v := range obj.meta {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+		w.Header().Set("Accept-Ranges", "bytes")
+		if len(obj.contentType) != 0 {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		data := obj.data
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); len(rng) != 0 {
+			start, end, ok := parseRange(rng, int64(len(data)))
+			if !ok {
+				writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", rng)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		if id := query.Get("uploadId"); len(id) != 0 {
+			delete(s.uploads, id)
+		} else {
+			delete(objects, key)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// parseRange handles the single range forms of the HTTP Range header
+func parseRange(rng string, size int64) (start int64, end int64, ok bool) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	if spec == rng || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(spec, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	var errGo error
+	switch {
+	case len(bounds[0]) == 0:
+		// Suffix range of the last N bytes
+		n, errGo := strconv.ParseInt(bounds[1], 10, 64)
+		if errGo != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, size != 0
+	case len(bounds[1]) == 0:
+		if start, errGo = strconv.ParseInt(bounds[0], 10, 64); errGo != nil {
+			return 0, 0, false
+		}
+		end = size - 1
+	default:
+		if start, errGo = strconv.ParseInt(bounds[0], 10, 64); errGo != nil {
+			return 0, 0, false
+		}
+		if end, errGo = strconv.ParseInt(bounds[1], 10, 64); errGo != nil {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start > end || start >= size {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string, query url.Values) {
+	objects, isPresent := s.buckets[bucket]
+	if !isPresent {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	maxKeys := 1000
+	if mk := query.Get("max-keys"); len(mk) != 0 {
+		if v, errGo := strconv.Atoi(mk); errGo == nil && v > 0 && v < maxKeys {
+			maxKeys = v
+		}
+	}
+	after := query.Get("continuation-token")
+	if len(after) == 0 {
+		after = query.Get("start-after")
+	}
+
+	keys := []string{}
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int    `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Name                  string    `xml:"Name"`
+		Prefix                string    `xml:"Prefix"`
+		KeyCount              int       `xml:"KeyCount"`
+		MaxKeys               int       `xml:"MaxKeys"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+		Contents              []content `xml:"Contents"`
+	}{
+		Name:    bucket,
+		Prefix:  prefix,
+		MaxKeys: maxKeys,
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		obj := objects[k]
+		result.Contents = append(result.Contents, content{
+			Key:          k,
+			LastModified: obj.lastModified.Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, objects map[string]*object, key string) {
+	source, errGo := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if errGo != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", errGo.Error())
+		return
+	}
+	parts := strings.SplitN(source, "/", 2)
+	if len(parts) != 2 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", source)
+		return
+	}
+	srcObjects, isPresent := s.buckets[parts[0]]
+	if !isPresent {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", parts[0])
+		return
+	}
+	src, isPresent := srcObjects[parts[1]]
+	if !isPresent {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", parts[1])
+		return
+	}
+
+	// As with S3 the copy is a new object, copies of multipart uploads lose their part count
+	obj := &object{
+		data:         src.data,
+		etag:         etagOf(src.data),
+		contentType:  src.contentType,
+		meta:         src.meta,
+		lastModified: time.Now().UTC(),
+	}
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		obj.meta = metaOf(r)
+		obj.contentType = r.Header.Get("Content-Type")
+	}
+	objects[key] = obj
+
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string   `xml:"LastModified"`
+		ETag         string   `xml:"ETag"`
+	}{LastModified: obj.lastModified.Format(time.RFC3339), ETag: obj.etag})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, query url.Values) {
+	up, isPresent := s.uploads[query.Get("uploadId")]
+	if !isPresent {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+		return
+	}
+	partNum, errGo := strconv.Atoi(query.Get("partNumber"))
+	if errGo != nil || partNum < 1 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "partNumber")
+		return
+	}
+	data, errGo := io.ReadAll(r.Body)
+	if errGo != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", errGo.Error())
+		return
+	}
+	up.parts[partNum] = data
+	w.Header().Set("ETag", etagOf(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, objects map[string]*object, bucket string, key string, id string) {
+	up, isPresent := s.uploads[id]
+	if !isPresent || up.bucket != bucket || up.key != key {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", id)
+		return
+	}
+
+	request := struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}{}
+	if errGo := xml.NewDecoder(r.Body).Decode(&request); errGo != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", errGo.Error())
+		return
+	}
+
+	data := bytes.Buffer{}
+	sums := []byte{}
+	for _, part := range request.Parts {
+		partData, isPresent := up.parts[part.PartNumber]
+		if !isPresent || etagOf(partData) != part.ETag {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", strconv.Itoa(part.PartNumber))
+			return
+		}
+		data.Write(partData)
+		sum := md5.Sum(partData)
+		sums = append(sums, sum[:]...)
+	}
+	sum := md5.Sum(sums)
+
+	obj := &object{
+		data:         data.Bytes(),
+		etag:         fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(request.Parts)),
+		contentType:  up.contentType,
+		meta:         up.meta,
+		lastModified: time.Now().UTC(),
+	}
+	objects[key] = obj
+	delete(s.uploads, id)
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Bucket: bucket, Key: key, ETag: obj.etag})
+}
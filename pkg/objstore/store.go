@@ -0,0 +1,457 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package objstore // import "github.com/leaf-ai/go-service/pkg/objstore"
+
+// This file contains the implementation of a client for S3 compatible object stores such as
+// AWS S3 and minio.  Credentials are supplied using the aws_gsc package, and custom
+// endpoints with path style addressing can be used to reach stores other than AWS.
+//
+// Objects uploaded using this client have a SHA256 checksum of their contents recorded as
+// user metadata, the checksum is verified when whole objects are downloaded.
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/archive"
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+)
+
+const (
+	// ChecksumKey is the user metadata key used to store the SHA256 checksum of an object
+	ChecksumKey = "Sha256"
+
+	// maxCopySize is the largest object that can be copied using a single request
+	maxCopySize = 5 * 1024 * 1024 * 1024
+)
+
+// StoreOpts contains the parameters used to configure access to an object store
+//
+type StoreOpts struct {
+	// Endpoint can be used to specify a URL for non AWS stores such as minio
+	Endpoint string
+	// PathStyle forces the bucket to appear in the URL path rather than the host name,
+	// typically needed for minio and other S3 compatible stores
+	PathStyle bool
+	// Retries is the maximum number of times a failing request is retried, defaults to 3
+	Retries int
+	// PartSize is the size of the parts used for multipart uploads, defaults to 5MB
+	PartSize int64
+	// Concurrency is the number of parts uploaded in parallel, defaults to 5
+	Concurrency int
+}
+
+// ObjectInfo describes an object within a bucket
+//
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+	Checksum     string // The hex encoded SHA256 of the object contents, if known
+	Metadata     map[string]string
+}
+
+// Store is a client for a single bucket within an S3 compatible object store
+//
+type Store struct {
+	Bucket string
+
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewStore creates a client for a bucket within an object store using the supplied credentials
+//
+func NewStore(cred *aws_gsc.AWSCred, bucket string, opts StoreOpts) (s *Store, err kv.Error) {
+
+	if len(bucket) == 0 {
+		return nil, kv.NewError("bucket not specified").With("stack", stack.Trace().TrimRuntime())
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+
+	region := cred.Region
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	cfg := &aws.Config{
+		Region:           aws.String(region),
+		Credentials:      cred.Creds,
+		S3ForcePathStyle: aws.Bool(opts.PathStyle),
+		MaxRetries:       aws.Int(opts.Retries),
+	}
+	if len(opts.Endpoint) != 0 {
+		cfg.Endpoint = aws.String(opts.Endpoint)
+		cfg.DisableSSL = aws.Bool(strings.HasPrefix(opts.Endpoint, "http://"))
+	}
+
+	sess, errGo := session.NewSession(cfg)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("bucket", bucket, "endpoint", opts.Endpoint).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	s = &Store{
+		Bucket: bucket,
+		client: s3.New(sess),
+	}
+	s.uploader = s3manager.NewUploaderWithClient(s.client, func(u *s3manager.Uploader) {
+		if opts.PartSize > 0 {
+			u.PartSize = opts.PartSize
+		}
+		if opts.Concurrency > 0 {
+			u.Concurrency = opts.Concurrency
+		}
+	})
+	return s, nil
+}
+
+// IsNotFound can be used to test errors returned by the store to see if they were
+// caused by a missing object or bucket
+//
+func IsNotFound(err kv.Error) bool {
+	if err == nil {
+		return false
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode() == http.StatusNotFound
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) wrap(errGo error, key string) (err kv.Error) {
+	return kv.Wrap(errGo).With("bucket", s.Bucket, "key", key).With("stack", stack.Trace().TrimRuntime())
+}
+
+func checksumOf(metadata map[string]*string) (checksum string) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, ChecksumKey) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+func metadataOf(metadata map[string]*string) (values map[string]string) {
+	values = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		values[k] = aws.StringValue(v)
+	}
+	return values
+}
+
+// Put uploads the contents of a reader into an object, large objects are uploaded using
+// multipart uploads while streaming from the reader.  The SHA256 checksum of the contents is
+// stored with the object.
+//
+// When the reader is not an io.ReadSeeker the checksum is only known once the upload is
+// complete, it is then added by copying the object onto itself.  Until the copy completes
+// other readers can see the object without its checksum.  Objects larger than a single copy
+// allows are left without a stored checksum.
+//
+func (s *Store) Put(ctx context.Context, key string, rdr io.Reader, contentType string, metadata map[string]string) (info *ObjectInfo, err kv.Error) {
+
+	meta := make(map[string]*string, len(metadata)+1)
+	for k, v := range metadata {
+		meta[k] = aws.String(v)
+	}
+
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		Metadata: meta,
+	}
+	if len(contentType) != 0 {
+		input.ContentType = aws.String(contentType)
+	}
+
+	// When the content can be re-read the checksum is calculated ahead of the upload
+	// and stored with it, otherwise it is calculated as the data streams past and is
+	// added to the object afterwards
+	hasher := sha256.New()
+	checksum := ""
+	if seeker, ok := rdr.(io.ReadSeeker); ok {
+		start, errGo := seeker.Seek(0, io.SeekCurrent)
+		if errGo != nil {
+			return nil, s.wrap(errGo, key)
+		}
+		if _, errGo := io.Copy(hasher, seeker); errGo != nil {
+			return nil, s.wrap(errGo, key)
+		}
+		if _, errGo := seeker.Seek(start, io.SeekStart); errGo != nil {
+			return nil, s.wrap(errGo, key)
+		}
+		checksum = hex.EncodeToString(hasher.Sum(nil))
+		meta[ChecksumKey] = aws.String(checksum)
+		input.Body = seeker
+	} else {
+		input.Body = io.TeeReader(rdr, hasher)
+	}
+
+	counter := &countingReader{rdr: input.Body}
+	input.Body = counter
+
+	result, errGo := s.uploader.UploadWithContext(ctx, input)
+	if errGo != nil {
+		return nil, s.wrap(errGo, key)
+	}
+	etag := aws.StringValue(result.ETag)
+
+	if len(checksum) == 0 {
+		checksum = hex.EncodeToString(hasher.Sum(nil))
+		if counter.count <= maxCopySize {
+			meta[ChecksumKey] = aws.String(checksum)
+			// The copy creates a new version of the object with its own ETag
+			if etag, err = s.replaceMetadata(ctx, key, input.ContentType, meta); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         counter.count,
+		ETag:         etag,
+		ContentType:  contentType,
+		LastModified: time.Now(),
+		Checksum:     checksum,
+		Metadata:     metadataOf(meta),
+	}, nil
+}
+
+// replaceMetadata copies an object onto itself to update its metadata, returning the ETag
+// of the copy
+func (s *Store) replaceMetadata(ctx context.Context, key string, contentType *string, meta map[string]*string) (etag string, err kv.Error) {
+	output, errGo := s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(url.PathEscape(s.Bucket + "/" + key)),
+		ContentType:       contentType,
+		Metadata:          meta,
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	})
+	if errGo != nil {
+		return "", s.wrap(errGo, key)
+	}
+	if output.CopyObjectResult == nil {
+		return "", nil
+	}
+	return aws.StringValue(output.CopyObjectResult.ETag), nil
+}
+
+// PutTar streams a tar archive of the files cataloged by the TarWriter into an object using a
+// multipart upload, avoiding the need to stage the archive on local storage
+//
+func (s *Store) PutTar(ctx context.Context, key string, files *archive.TarWriter, metadata map[string]string) (info *ObjectInfo, err kv.Error) {
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		tw := tar.NewWriter(pw)
+		if err := files.Write(tw); err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		_ = pw.CloseWithError(tw.Close())
+	}()
+
+	info, err = s.Put(ctx, key, pr, "application/x-tar", metadata)
+	// Unblock the writer should the upload have stopped early
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	return info, err
+}
+
+// Get returns a reader for the contents of an object along with its details.  If the object has a
+// checksum it is verified as the contents are read, with a mismatch returned as an error by the
+// reader once the end of the contents are reached.
+//
+func (s *Store) Get(ctx context.Context, key string) (rdr io.ReadCloser, info *ObjectInfo, err kv.Error) {
+	return s.get(ctx, key, "")
+}
+
+// GetRange returns a reader for length bytes of an object starting at the offset.  A negative
+// length reads until the end of the object.  Checksums are not verified for ranged reads.
+//
+func (s *Store) GetRange(ctx context.Context, key string, offset int64, length int64) (rdr io.ReadCloser, info *ObjectInfo, err kv.Error) {
+	if offset < 0 || length == 0 {
+		return nil, nil, kv.NewError("invalid range").With("bucket", s.Bucket, "key", key, "offset", offset, "length", length).With("stack", stack.Trace().TrimRuntime())
+	}
+	rng := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length > 0 {
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+	return s.get(ctx, key, rng)
+}
+
+func (s *Store) get(ctx context.Context, key string, rng string) (rdr io.ReadCloser, info *ObjectInfo, err kv.Error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	if len(rng) != 0 {
+		input.Range = aws.String(rng)
+	}
+
+	output, errGo := s.client.GetObjectWithContext(ctx, input)
+	if errGo != nil {
+		return nil, nil, s.wrap(errGo, key)
+	}
+
+	info = &ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ETag:         aws.StringValue(output.ETag),
+		ContentType:  aws.StringValue(output.ContentType),
+		LastModified: aws.TimeValue(output.LastModified),
+		Checksum:     checksumOf(output.Metadata),
+		Metadata:     metadataOf(output.Metadata),
+	}
+
+	if len(rng) != 0 || len(info.Checksum) == 0 {
+		return output.Body, info, nil
+	}
+
+	return &verifyingReader{
+		body:     output.Body,
+		hasher:   sha256.New(),
+		expected: info.Checksum,
+		key:      key,
+	}, info, nil
+}
+
+// Stat returns the details of an object without retrieving its contents
+//
+func (s *Store) Stat(ctx context.Context, key string) (info *ObjectInfo, err kv.Error) {
+	output, errGo := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if errGo != nil {
+		return nil, s.wrap(errGo, key)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ETag:         aws.StringValue(output.ETag),
+		ContentType:  aws.StringValue(output.ContentType),
+		LastModified: aws.TimeValue(output.LastModified),
+		Checksum:     checksumOf(output.Metadata),
+		Metadata:     metadataOf(output.Metadata),
+	}, nil
+}
+
+// List returns the details of all objects with keys that start with the prefix, the
+// details do not include the metadata or checksums of the objects
+//
+func (s *Store) List(ctx context.Context, prefix string) (infos []ObjectInfo, err kv.Error) {
+	infos = []ObjectInfo{}
+	errGo := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range page.Contents {
+			infos = append(infos, ObjectInfo{
+				Key:          aws.StringValue(item.Key),
+				Size:         aws.Int64Value(item.Size),
+				ETag:         aws.StringValue(item.ETag),
+				LastModified: aws.TimeValue(item.LastModified),
+			})
+		}
+		return true
+	})
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("bucket", s.Bucket, "prefix", prefix).With("stack", stack.Trace().TrimRuntime())
+	}
+	return infos, nil
+}
+
+// Copy will duplicate an object within the bucket including its metadata
+//
+func (s *Store) Copy(ctx context.Context, srcKey string, dstKey string) (err kv.Error) {
+	_, errGo := s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(url.PathEscape(s.Bucket + "/" + srcKey)),
+	})
+	if errGo != nil {
+		return s.wrap(errGo, srcKey).With("destination", dstKey)
+	}
+	return nil
+}
+
+// Delete removes an object from the bucket, deleting an object that does not exist is not an error
+//
+func (s *Store) Delete(ctx context.Context, key string) (err kv.Error) {
+	_, errGo := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if errGo != nil {
+		return s.wrap(errGo, key)
+	}
+	return nil
+}
+
+// countingReader tracks the number of bytes read from a stream
+type countingReader struct {
+	rdr   io.Reader
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, errGo error) {
+	n, errGo = c.rdr.Read(p)
+	c.count += int64(n)
+	return n, errGo
+}
+
+// verifyingReader checks the SHA256 of an objects contents once they have been fully read
+type verifyingReader struct {
+	body     io.ReadCloser
+	hasher   hash.Hash
+	expected string
+	key      string
+}
+
+func (v *verifyingReader) Read(p []byte) (n int, errGo error) {
+	n, errGo = v.body.Read(p)
+	v.hasher.Write(p[:n])
+	if errGo == io.EOF {
+		if actual := hex.EncodeToString(v.hasher.Sum(nil)); actual != v.expected {
+			return n, kv.NewError("checksum mismatch").With("key", v.key, "expected", v.expected, "actual", actual).With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	return n, errGo
+}
+
+func (v *verifyingReader) Close() (errGo error) {
+	return v.body.Close()
+}
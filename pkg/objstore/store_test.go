@@ -0,0 +1,238 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package objstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+
+	"github.com/leaf-ai/go-service/pkg/archive"
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/objstore/fakes3"
+)
+
+func newTestStore(t *testing.T) (server *fakes3.Server, s *Store) {
+	server = fakes3.New()
+	server.CreateBucket("test-bucket")
+
+	cred := &aws_gsc.AWSCred{
+		Project: "test",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("test_access_key", "test_secret_key", ""),
+	}
+	s, err := NewStore(cred, "test-bucket", StoreOpts{Endpoint: server.URL, PathStyle: true})
+	if err != nil {
+		server.Close()
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	return server, s
+}
+
+// TestStoreRoundTrip exercises the basic object operations against the fake server
+//
+func TestStoreRoundTrip(t *testing.T) {
+	server, s := newTestStore(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	info, err := s.Put(ctx, "dir/fox.txt", bytes.NewReader(content), "text/plain", map[string]string{"Experiment": "1"})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if info.Size != int64(len(content)) || len(info.Checksum) == 0 {
+		t.Fatal("unexpected object info", info, "stack", stack.Trace().TrimRuntime())
+	}
+
+	stat, err := s.Stat(ctx, "dir/fox.txt")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if stat.Checksum != info.Checksum || stat.Size != info.Size || stat.Metadata["Experiment"] != "1" {
+		t.Fatal("unexpected stat", stat, "stack", stack.Trace().TrimRuntime())
+	}
+
+	rdr, _, err := s.Get(ctx, "dir/fox.txt")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	data, errGo := ioutil.ReadAll(rdr)
+	rdr.Close()
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("content mismatch", string(data), "stack", stack.Trace().TrimRuntime())
+	}
+
+	rdr, _, err = s.GetRange(ctx, "dir/fox.txt", 4, 5)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	data, _ = ioutil.ReadAll(rdr)
+	rdr.Close()
+	if string(data) != "quick" {
+		t.Fatal("unexpected range", string(data), "stack", stack.Trace().TrimRuntime())
+	}
+
+	if _, err = s.Put(ctx, "other.txt", bytes.NewReader(content), "", nil); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err = s.Copy(ctx, "dir/fox.txt", "dir/fox-copy.txt"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	infos, err := s.List(ctx, "dir/")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if len(infos) != 2 || infos[0].Key != "dir/fox-copy.txt" || infos[1].Key != "dir/fox.txt" {
+		t.Fatal("unexpected listing", infos, "stack", stack.Trace().TrimRuntime())
+	}
+
+	if err = s.Delete(ctx, "dir/fox.txt"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if _, err = s.Stat(ctx, "dir/fox.txt"); !IsNotFound(err) {
+		t.Fatal("deleted object still present", err, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestStoreMultipart checks that streamed content too large for a single part is uploaded
+// in parts and has a checksum recorded
+//
+func TestStoreMultipart(t *testing.T) {
+	server, s := newTestStore(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	content := make([]byte, 11*1024*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	// Hide the seeker from the store so that the content is streamed
+	info, err := s.Put(ctx, "large.bin", io.MultiReader(bytes.NewReader(content)), "", nil)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if info.Size != int64(len(content)) {
+		t.Fatal("unexpected size", info.Size, "stack", stack.Trace().TrimRuntime())
+	}
+
+	rdr, got, err := s.Get(ctx, "large.bin")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer rdr.Close()
+	if got.Checksum != info.Checksum {
+		t.Fatal("checksum not stored", got.Checksum, info.Checksum, "stack", stack.Trace().TrimRuntime())
+	}
+	if got.ETag != info.ETag {
+		t.Fatal("stale ETag returned", got.ETag, info.ETag, "stack", stack.Trace().TrimRuntime())
+	}
+	data, errGo := ioutil.ReadAll(rdr)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("content mismatch", "stack", stack.Trace().TrimRuntime())
+	}
+	if server.Uploads() != 0 {
+		t.Fatal("multipart upload left incomplete", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestStoreRetries checks that transient server failures are retried
+//
+func TestStoreRetries(t *testing.T) {
+	server, s := newTestStore(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	server.FailNext(2)
+	if _, err := s.Put(ctx, "retried.txt", bytes.NewReader([]byte("retried")), "", nil); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if _, err := s.Stat(ctx, "retried.txt"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestStorePutTar checks that the output of a TarWriter can be streamed into the store
+//
+func TestStorePutTar(t *testing.T) {
+	server, s := newTestStore(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	tmpDir, errGo := ioutil.TempDir("", "TestStorePutTar")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{"a.txt": "alpha", "b.txt": "bravo"}
+	for name, content := range files {
+		if errGo := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600); errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	tw, err := archive.NewTarWriter(tmpDir)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if _, err = s.PutTar(ctx, "artifact.tar", tw, nil); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	rdr, info, err := s.Get(ctx, "artifact.tar")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer rdr.Close()
+
+	// The content type matches that reported for tar files by the mime package
+	if info.ContentType != mime.TypeByExtension(".tar") {
+		t.Fatal("unexpected content type", info.ContentType, "stack", stack.Trace().TrimRuntime())
+	}
+
+	tr := tar.NewReader(rdr)
+	found := 0
+	for {
+		header, errGo := tr.Next()
+		if errGo == io.EOF {
+			break
+		}
+		if errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		data, _ := ioutil.ReadAll(tr)
+		if files[header.Name] != string(data) {
+			t.Fatal("unexpected archive member", header.Name, "stack", stack.Trace().TrimRuntime())
+		}
+		found++
+	}
+	if found != len(files) {
+		t.Fatal("archive members missing", found, "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package queue // import "github.com/leaf-ai/go-service/pkg/queue"
+
+// This file contains an in-memory implementation of the Queue interface with the same lease
+// semantics as the cloud implementations, intended for use in tests and single process
+// deployments.
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/jjeffery/kv" // MIT License
+)
+
+type memMsg struct {
+	id        string
+	body      []byte
+	attempts  int
+	handle    string
+	visibleAt time.Time
+}
+
+// Memory is an in-memory queue
+//
+type Memory struct {
+	opts Opts
+	msgs []*memMsg
+
+	// changed is closed and replaced whenever a message becomes visible
+	changed chan struct{}
+
+	sync.Mutex
+}
+
+// NewMemory creates an empty in-memory queue
+//
+func NewMemory(opts Opts) (q *Memory) {
+	return &Memory{
+		opts:    opts,
+		msgs:    []*memMsg{},
+		changed: make(chan struct{}),
+	}
+}
+
+// notify wakes any waiting receivers, the caller must hold the lock
+func (q *Memory) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// find locates a message using its lease, the caller must hold the lock
+func (q *Memory) find(msg *Message) (i int, found *memMsg) {
+	for i, m := range q.msgs {
+		if m.id == msg.ID && m.handle == msg.Handle && len(m.handle) != 0 {
+			if time.Now().Before(m.visibleAt) {
+				return i, m
+			}
+			break
+		}
+	}
+	return -1, nil
+}
+
+// Len returns the number of messages held by the queue including those that are leased
+//
+func (q *Memory) Len() (length int) {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.msgs)
+}
+
+// Send adds a message to the queue
+//
+func (q *Memory) Send(ctx context.Context, body []byte) (id string, err kv.Error) {
+	q.Lock()
+	defer q.Unlock()
+
+	m := &memMsg{
+		id:        xid.New().String(),
+		body:      append([]byte{}, body...),
+		visibleAt: time.Now(),
+	}
+	q.msgs = append(q.msgs, m)
+	q.notify()
+	return m.id, nil
+}
+
+// lease attempts to take a lease on the first visible message, returning the time at which
+// the next message becomes visible if none are available, the caller must hold the lock
+func (q *Memory) lease(visibility time.Duration) (msg *Message, next time.Time) {
+	now := time.Now()
+	for _, m := range q.msgs {
+		if m.visibleAt.After(now) {
+			if next.IsZero() || m.visibleAt.Before(next) {
+				next = m.visibleAt
+			}
+			continue
+		}
+		m.attempts++
+		m.handle = xid.New().String()
+		m.visibleAt = now.Add(visibility)
+		return &Message{
+			ID:       m.id,
+			Body:     append([]byte{}, m.body...),
+			Attempts: m.attempts,
+			Handle:   m.handle,
+		}, next
+	}
+	return nil, next
+}
+
+// Receive waits up to wait for a message and leases it for the visibility timeout
+//
+func (q *Memory) Receive(ctx context.Context, visibility time.Duration, wait time.Duration) (msg *Message, err kv.Error) {
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+
+	for {
+		q.Lock()
+		msg, next := q.lease(visibility)
+		changed := q.changed
+		q.Unlock()
+
+		if msg != nil {
+			dead, err := q.opts.deadLetter(ctx, q, msg)
+			if err != nil {
+				return nil, err
+			}
+			if !dead {
+				return msg, nil
+			}
+			continue
+		}
+
+		// Wake up when a leased or delayed message becomes visible again
+		var visible <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			visible = timer.C
+		}
+
+		expired := false
+		select {
+		case <-ctx.Done():
+			expired = true
+		case <-deadline.C:
+			expired = true
+		case <-changed:
+		case <-visible:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if expired {
+			return nil, nil
+		}
+	}
+}
+
+// Extend resets the lease on a message to expire after the visibility timeout
+//
+func (q *Memory) Extend(ctx context.Context, msg *Message, visibility time.Duration) (err kv.Error) {
+	q.Lock()
+	defer q.Unlock()
+
+	_, m := q.find(msg)
+	if m == nil {
+		return errLeaseLost(msg)
+	}
+	m.visibleAt = time.Now().Add(visibility)
+	return nil
+}
+
+// Ack deletes a leased message from the queue
+//
+func (q *Memory) Ack(ctx context.Context, msg *Message) (err kv.Error) {
+	q.Lock()
+	defer q.Unlock()
+
+	i, m := q.find(msg)
+	if m == nil {
+		return errLeaseLost(msg)
+	}
+	q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+	return nil
+}
+
+// Nack releases the lease on a message so that it is redelivered after the delay
+//
+func (q *Memory) Nack(ctx context.Context, msg *Message, delay time.Duration) (err kv.Error) {
+	q.Lock()
+	defer q.Unlock()
+
+	_, m := q.find(msg)
+	if m == nil {
+		return errLeaseLost(msg)
+	}
+	m.handle = ""
+	m.visibleAt = time.Now().Add(delay)
+	q.notify()
+	return nil
+}
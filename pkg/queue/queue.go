@@ -0,0 +1,214 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package queue // import "github.com/leaf-ai/go-service/pkg/queue"
+
+// This file contains the definition of a message queue abstraction that uses lease semantics.
+// Messages received from a queue are hidden from other receivers for a visibility timeout,
+// during which time the receiver can extend the lease, acknowledge the message to delete it,
+// or negatively acknowledge it to have it redelivered after a delay.  Messages that have been
+// received more than a maximum number of times are moved to a dead letter queue.
+//
+// The Process function implements the common receive, heartbeat and acknowledge loop used
+// by runners pulling work from a queue.
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// Message is a single message that has been leased from a queue
+//
+type Message struct {
+	ID   string
+	Body []byte
+	// Attempts is the number of times the message has been received, including this time
+	Attempts int
+	// Handle identifies the lease on the message and changes each time it is received
+	Handle string
+}
+
+// Queue is implemented by message queues that support leasing messages
+//
+type Queue interface {
+	// Send adds a message to the queue
+	Send(ctx context.Context, body []byte) (id string, err kv.Error)
+	// Receive waits up to wait for a message and leases it for the visibility timeout, a nil
+	// message is returned if no message became available
+	Receive(ctx context.Context, visibility time.Duration, wait time.Duration) (msg *Message, err kv.Error)
+	// Extend resets the lease on a message to expire after the visibility timeout
+	Extend(ctx context.Context, msg *Message, visibility time.Duration) (err kv.Error)
+	// Ack deletes a leased message from the queue
+	Ack(ctx context.Context, msg *Message) (err kv.Error)
+	// Nack releases the lease on a message so that it is redelivered after the delay
+	Nack(ctx context.Context, msg *Message, delay time.Duration) (err kv.Error)
+}
+
+// Opts contains the dead letter handling options common to all queue implementations
+//
+type Opts struct {
+	// MaxAttempts is the number of times a message can be received before it is dead lettered,
+	// zero allows an unlimited number of attempts
+	MaxAttempts int
+	// DeadLetter is the queue messages are moved to once they exceed their attempts, if nil
+	// the messages are dropped
+	DeadLetter Queue
+}
+
+// deadLetter checks if a received message has exceeded its attempts and if so moves it to the
+// dead letter queue, returning true if the message was removed from the queue
+func (opts *Opts) deadLetter(ctx context.Context, q Queue, msg *Message) (dead bool, err kv.Error) {
+	if opts.MaxAttempts <= 0 || msg.Attempts <= opts.MaxAttempts {
+		return false, nil
+	}
+	if opts.DeadLetter != nil {
+		if _, err = opts.DeadLetter.Send(ctx, msg.Body); err != nil {
+			return false, err.With("id", msg.ID)
+		}
+	}
+	if err = q.Ack(ctx, msg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Handler is used to process messages from a queue, returning nil results in the message
+// being acknowledged and returning an error results in the message being redelivered.  The
+// ctx is cancelled should the lease on the message be lost.
+//
+type Handler func(ctx context.Context, msg *Message) (err kv.Error)
+
+// ProcessOpts contains the parameters used by Process to manage leases
+//
+type ProcessOpts struct {
+	// Visibility is the duration of the lease on messages, defaults to 30 seconds
+	Visibility time.Duration
+	// Heartbeat is the interval at which leases are extended, defaults to a third of the visibility
+	Heartbeat time.Duration
+	// Wait is the maximum time a single receive will wait for a message, defaults to 20 seconds
+	Wait time.Duration
+	// RetryDelay is the delay before a message that failed to be handled is redelivered
+	RetryDelay time.Duration
+	// Beat, if set, is called each time the processing loop runs, it can be used to drive a
+	// liveness heartbeat
+	Beat func()
+}
+
+// Process receives messages from a queue one at a time passing them to the handler, and extends
+// the lease on the message while the handler runs.  Errors from the queue or the handler are
+// sent to the errorC channel if one is supplied.
+//
+// This is a blocking function that will return when the ctx is Done().
+//
+func Process(ctx context.Context, q Queue, opts ProcessOpts, handler Handler, errorC chan<- kv.Error) {
+
+	if opts.Visibility <= 0 {
+		opts.Visibility = 30 * time.Second
+	}
+	if opts.Heartbeat <= 0 || opts.Heartbeat >= opts.Visibility {
+		opts.Heartbeat = opts.Visibility / 3
+	}
+	if opts.Wait <= 0 {
+		opts.Wait = 20 * time.Second
+	}
+
+	report := func(err kv.Error) {
+		if errorC == nil {
+			return
+		}
+		select {
+		case errorC <- err:
+		case <-time.After(time.Second):
+		}
+	}
+
+	for {
+		if opts.Beat != nil {
+			opts.Beat()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		msg, err := q.Receive(ctx, opts.Visibility, opts.Wait)
+		if err != nil {
+			report(err)
+			// Avoid spinning against a queue that is failing
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		if err = handle(ctx, q, opts, msg, handler); err != nil {
+			report(err)
+		}
+	}
+}
+
+// handle runs the handler for a single message while keeping its lease alive
+func handle(ctx context.Context, q Queue, opts ProcessOpts, msg *Message, handler Handler) (err kv.Error) {
+
+	msgCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	heartbeatC := make(chan kv.Error, 1)
+	go func() {
+		defer close(heartbeatC)
+
+		tick := time.NewTicker(opts.Heartbeat)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-msgCtx.Done():
+				return
+			case <-tick.C:
+				if err := q.Extend(msgCtx, msg, opts.Visibility); err != nil {
+					// An extension interrupted by the handler returning, or by the caller
+					// stopping, does not mean the lease was lost
+					if msgCtx.Err() != nil {
+						return
+					}
+					// Once the lease is lost the handler should stop its work
+					heartbeatC <- err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = handler(msgCtx, msg)
+	cancel()
+
+	if lost := <-heartbeatC; lost != nil {
+		return lost.With("id", msg.ID)
+	}
+
+	if err != nil {
+		if errNack := q.Nack(ctx, msg, opts.RetryDelay); errNack != nil {
+			return errNack.With("handler_error", err.Error())
+		}
+		return err.With("id", msg.ID, "attempts", msg.Attempts)
+	}
+
+	if err = q.Ack(ctx, msg); err != nil {
+		return err
+	}
+	return nil
+}
+
+func errLeaseLost(msg *Message) (err kv.Error) {
+	return kv.NewError("lease lost").With("id", msg.ID, "handle", msg.Handle).With("stack", stack.Trace().TrimRuntime())
+}
@@ -0,0 +1,221 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+// TestMemoryLeases checks the lease, ack and nack semantics of the in-memory queue
+//
+func TestMemoryLeases(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := NewMemory(Opts{})
+	if _, err := q.Send(ctx, []byte("work")); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	msg, err := q.Receive(ctx, 100*time.Millisecond, time.Second)
+	if err != nil || msg == nil {
+		t.Fatal("message not received", err, "stack", stack.Trace().TrimRuntime())
+	}
+	if string(msg.Body) != "work" || msg.Attempts != 1 {
+		t.Fatal("unexpected message", msg, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// While leased the message is invisible
+	if other, _ := q.Receive(ctx, time.Second, 20*time.Millisecond); other != nil {
+		t.Fatal("leased message was redelivered", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Once the lease expires the message is redelivered and the old lease is invalid
+	redelivered, err := q.Receive(ctx, time.Second, time.Second)
+	if err != nil || redelivered == nil || redelivered.Attempts != 2 {
+		t.Fatal("message not redelivered after lease expiry", redelivered, err, "stack", stack.Trace().TrimRuntime())
+	}
+	if err = q.Ack(ctx, msg); err == nil {
+		t.Fatal("ack succeeded using an expired lease", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// A nack with a delay hides the message for the delay
+	if err = q.Nack(ctx, redelivered, 100*time.Millisecond); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if other, _ := q.Receive(ctx, time.Second, 20*time.Millisecond); other != nil {
+		t.Fatal("nacked message delivered before its delay", "stack", stack.Trace().TrimRuntime())
+	}
+	msg, err = q.Receive(ctx, time.Second, time.Second)
+	if err != nil || msg == nil || msg.Attempts != 3 {
+		t.Fatal("nacked message not redelivered", msg, err, "stack", stack.Trace().TrimRuntime())
+	}
+
+	if err = q.Extend(ctx, msg, time.Second); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err = q.Ack(ctx, msg); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if q.Len() != 0 {
+		t.Fatal("acknowledged message still queued", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestMemoryDeadLetter checks that messages exceeding their attempts are moved to
+// the dead letter queue
+//
+func TestMemoryDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dlq := NewMemory(Opts{})
+	q := NewMemory(Opts{MaxAttempts: 2, DeadLetter: dlq})
+
+	if _, err := q.Send(ctx, []byte("poison")); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	for i := 0; i != 2; i++ {
+		msg, err := q.Receive(ctx, time.Second, time.Second)
+		if err != nil || msg == nil {
+			t.Fatal("message not received", err, "stack", stack.Trace().TrimRuntime())
+		}
+		if err = q.Nack(ctx, msg, 0); err != nil {
+			t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	if msg, _ := q.Receive(ctx, time.Second, 50*time.Millisecond); msg != nil {
+		t.Fatal("message delivered beyond its maximum attempts", "stack", stack.Trace().TrimRuntime())
+	}
+	if q.Len() != 0 || dlq.Len() != 1 {
+		t.Fatal("message not dead lettered", q.Len(), dlq.Len(), "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestProcess checks that the processing loop keeps leases alive for slow handlers and
+// retries messages that fail
+//
+func TestProcess(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := NewMemory(Opts{})
+	for _, body := range []string{"slow", "fail"} {
+		if _, err := q.Send(ctx, []byte(body)); err != nil {
+			t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	calls := int32(0)
+	failed := int32(0)
+	beats := int32(0)
+	doneC := make(chan struct{})
+
+	handler := func(ctx context.Context, msg *Message) (err kv.Error) {
+		atomic.AddInt32(&calls, 1)
+		switch string(msg.Body) {
+		case "slow":
+			if msg.Attempts != 1 {
+				t.Error("slow message was redelivered while being handled")
+			}
+			// Outlive the visibility timeout several times over
+			select {
+			case <-time.After(300 * time.Millisecond):
+			case <-ctx.Done():
+				t.Error("lease lost while handling")
+			}
+		case "fail":
+			if msg.Attempts == 1 {
+				atomic.AddInt32(&failed, 1)
+				return kv.NewError("transient failure")
+			}
+			close(doneC)
+		}
+		return nil
+	}
+
+	errorC := make(chan kv.Error, 10)
+	procCtx, procCancel := context.WithCancel(ctx)
+	defer procCancel()
+
+	go Process(procCtx, q, ProcessOpts{
+		Visibility: 100 * time.Millisecond,
+		Wait:       50 * time.Millisecond,
+		Beat:       func() { atomic.AddInt32(&beats, 1) },
+	}, handler, errorC)
+
+	select {
+	case <-doneC:
+	case <-ctx.Done():
+		t.Fatal("messages were not processed", "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Allow the final ack to happen
+	time.Sleep(50 * time.Millisecond)
+	procCancel()
+
+	if atomic.LoadInt32(&calls) != 3 || atomic.LoadInt32(&failed) != 1 {
+		t.Fatal("unexpected handler calls", calls, failed, "stack", stack.Trace().TrimRuntime())
+	}
+	if atomic.LoadInt32(&beats) == 0 {
+		t.Fatal("processing loop did not beat", "stack", stack.Trace().TrimRuntime())
+	}
+	if q.Len() != 0 {
+		t.Fatal("messages remain queued", q.Len(), "stack", stack.Trace().TrimRuntime())
+	}
+	select {
+	case err := <-errorC:
+		if err.Error() == "" {
+			t.Fatal("empty error reported", "stack", stack.Trace().TrimRuntime())
+		}
+	default:
+		t.Fatal("handler failure was not reported", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// blockingExtend is a queue whose lease extensions do not complete until their ctx is done
+type blockingExtend struct {
+	*Memory
+}
+
+func (q *blockingExtend) Extend(ctx context.Context, msg *Message, visibility time.Duration) (err kv.Error) {
+	<-ctx.Done()
+	return kv.Wrap(ctx.Err()).With("stack", stack.Trace().TrimRuntime())
+}
+
+// TestHandleExtendInFlight checks that a lease extension still in flight when the handler
+// returns does not prevent the message from being acknowledged
+//
+func TestHandleExtendInFlight(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := &blockingExtend{Memory: NewMemory(Opts{})}
+	if _, err := q.Send(ctx, []byte("work")); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	msg, err := q.Receive(ctx, 5*time.Second, time.Second)
+	if err != nil || msg == nil {
+		t.Fatal("message not received", err, "stack", stack.Trace().TrimRuntime())
+	}
+
+	opts := ProcessOpts{Visibility: 5 * time.Second, Heartbeat: 10 * time.Millisecond}
+	handler := func(ctx context.Context, msg *Message) (err kv.Error) {
+		// Return while an extension is waiting
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}
+	if err = handle(ctx, q, opts, msg, handler); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if q.Len() != 0 {
+		t.Fatal("message was not acknowledged", q.Len(), "stack", stack.Trace().TrimRuntime())
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package queue // import "github.com/leaf-ai/go-service/pkg/queue"
+
+// This file contains an implementation of the Queue interface using AWS SQS, with credentials
+// supplied by the aws_gsc package.  Message bodies sent using SQS must be valid unicode text.
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+)
+
+const (
+	// maxSQSWait is the longest long poll SQS supports
+	maxSQSWait = 20 * time.Second
+	// maxSQSVisibility is the longest visibility timeout SQS supports
+	maxSQSVisibility = 12 * time.Hour
+)
+
+// SQS is a queue implemented using an AWS SQS queue
+//
+type SQS struct {
+	URL string
+
+	opts   Opts
+	client *sqs.SQS
+}
+
+// NewSQS creates a client for the SQS queue with the supplied URL.  The endpoint can be
+// used to override the AWS service endpoint, for example when using a local stand-in.
+//
+func NewSQS(cred *aws_gsc.AWSCred, queueURL string, endpoint string, opts Opts) (q *SQS, err kv.Error) {
+
+	if len(queueURL) == 0 {
+		return nil, kv.NewError("queue URL not specified").With("stack", stack.Trace().TrimRuntime())
+	}
+
+	cfg := &aws.Config{
+		Region:      aws.String(cred.Region),
+		Credentials: cred.Creds,
+	}
+	if len(endpoint) != 0 {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+
+	sess, errGo := session.NewSession(cfg)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("queue", queueURL).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	return &SQS{
+		URL:    queueURL,
+		opts:   opts,
+		client: sqs.New(sess),
+	}, nil
+}
+
+func (q *SQS) wrap(errGo error) (err kv.Error) {
+	return kv.Wrap(errGo).With("queue", q.URL).With("stack", stack.Trace().TrimRuntime())
+}
+
+// seconds converts a duration to whole seconds, rounding up and clamping to the range
+// accepted by SQS
+func seconds(d time.Duration, max time.Duration) (secs int64) {
+	if d <= 0 {
+		return 0
+	}
+	if d > max {
+		d = max
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// Send adds a message to the queue
+//
+func (q *SQS) Send(ctx context.Context, body []byte) (id string, err kv.Error) {
+	output, errGo := q.client.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.URL),
+		MessageBody: aws.String(string(body)),
+	})
+	if errGo != nil {
+		return "", q.wrap(errGo)
+	}
+	return aws.StringValue(output.MessageId), nil
+}
+
+// Receive waits up to wait for a message and leases it for the visibility timeout, SQS limits
+// the wait to 20 seconds
+//
+func (q *SQS) Receive(ctx context.Context, visibility time.Duration, wait time.Duration) (msg *Message, err kv.Error) {
+
+	deadline := time.Now().Add(wait)
+	for {
+		output, errGo := q.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(q.URL),
+			MaxNumberOfMessages:         aws.Int64(1),
+			VisibilityTimeout:           aws.Int64(seconds(visibility, maxSQSVisibility)),
+			WaitTimeSeconds:             aws.Int64(seconds(time.Until(deadline), maxSQSWait)),
+			MessageSystemAttributeNames: []*string{aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount)},
+		})
+		if errGo != nil {
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+			return nil, q.wrap(errGo)
+		}
+		if len(output.Messages) == 0 {
+			return nil, nil
+		}
+
+		item := output.Messages[0]
+		msg = &Message{
+			ID:       aws.StringValue(item.MessageId),
+			Body:     []byte(aws.StringValue(item.Body)),
+			Attempts: 1,
+			Handle:   aws.StringValue(item.ReceiptHandle),
+		}
+		if count, isPresent := item.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]; isPresent {
+			if attempts, errGo := strconv.Atoi(aws.StringValue(count)); errGo == nil {
+				msg.Attempts = attempts
+			}
+		}
+
+		dead, err := q.opts.deadLetter(ctx, q, msg)
+		if err != nil {
+			return nil, err
+		}
+		if !dead {
+			return msg, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil
+		}
+	}
+}
+
+func (q *SQS) changeVisibility(ctx context.Context, msg *Message, timeout time.Duration) (err kv.Error) {
+	_, errGo := q.client.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.URL),
+		ReceiptHandle:     aws.String(msg.Handle),
+		VisibilityTimeout: aws.Int64(seconds(timeout, maxSQSVisibility)),
+	})
+	if errGo != nil {
+		return q.wrap(errGo).With("id", msg.ID)
+	}
+	return nil
+}
+
+// Extend resets the lease on a message to expire after the visibility timeout
+//
+func (q *SQS) Extend(ctx context.Context, msg *Message, visibility time.Duration) (err kv.Error) {
+	return q.changeVisibility(ctx, msg, visibility)
+}
+
+// Ack deletes a leased message from the queue
+//
+func (q *SQS) Ack(ctx context.Context, msg *Message) (err kv.Error) {
+	_, errGo := q.client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.URL),
+		ReceiptHandle: aws.String(msg.Handle),
+	})
+	if errGo != nil {
+		return q.wrap(errGo).With("id", msg.ID)
+	}
+	return nil
+}
+
+// Nack releases the lease on a message so that it is redelivered after the delay
+//
+func (q *SQS) Nack(ctx context.Context, msg *Message, delay time.Duration) (err kv.Error) {
+	return q.changeVisibility(ctx, msg, delay)
+}
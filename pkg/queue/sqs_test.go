@@ -0,0 +1,168 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package queue
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+)
+
+// sqsStandIn implements the subset of the SQS JSON protocol used by the SQS queue on top
+// of an in-memory queue
+type sqsStandIn struct {
+	q *Memory
+}
+
+func md5Of(body string) string {
+	sum := md5.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *sqsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		MessageBody       string
+		ReceiptHandle     string
+		VisibilityTimeout int64
+		WaitTimeSeconds   int64
+	}{}
+	if errGo := json.NewDecoder(r.Body).Decode(&request); errGo != nil {
+		http.Error(w, errGo.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Receipt handles carry both the message ID and the lease handle
+	lease := &Message{}
+	if parts := strings.SplitN(request.ReceiptHandle, "|", 2); len(parts) == 2 {
+		lease.ID = parts[0]
+		lease.Handle = parts[1]
+	}
+
+	response := map[string]interface{}{}
+	var err error
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.") {
+	case "SendMessage":
+		id, errKV := s.q.Send(r.Context(), []byte(request.MessageBody))
+		if errKV == nil {
+			response["MessageId"] = id
+			response["MD5OfMessageBody"] = md5Of(request.MessageBody)
+		} else {
+			err = errKV
+		}
+	case "ReceiveMessage":
+		msg, errKV := s.q.Receive(r.Context(), time.Duration(request.VisibilityTimeout)*time.Second, time.Duration(request.WaitTimeSeconds)*time.Second)
+		if errKV != nil {
+			err = errKV
+			break
+		}
+		messages := []map[string]interface{}{}
+		if msg != nil {
+			messages = append(messages, map[string]interface{}{
+				"MessageId":     msg.ID,
+				"ReceiptHandle": msg.ID + "|" + msg.Handle,
+				"Body":          string(msg.Body),
+				"MD5OfBody":     md5Of(string(msg.Body)),
+				"Attributes":    map[string]string{"ApproximateReceiveCount": strconv.Itoa(msg.Attempts)},
+			})
+		}
+		response["Messages"] = messages
+	case "ChangeMessageVisibility":
+		timeout := time.Duration(request.VisibilityTimeout) * time.Second
+		if timeout == 0 {
+			if errKV := s.q.Nack(r.Context(), lease, 0); errKV != nil {
+				err = errKV
+			}
+		} else if errKV := s.q.Extend(r.Context(), lease, timeout); errKV != nil {
+			err = errKV
+		}
+	case "DeleteMessage":
+		if errKV := s.q.Ack(r.Context(), lease); errKV != nil {
+			err = errKV
+		}
+	default:
+		http.Error(w, "unsupported action", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"__type":  "com.amazonaws.sqs#ReceiptHandleIsInvalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// TestSQS exercises the SQS queue against a local stand-in including client side dead lettering
+//
+func TestSQS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	backing := NewMemory(Opts{})
+	server := httptest.NewServer(&sqsStandIn{q: backing})
+	defer server.Close()
+
+	cred := &aws_gsc.AWSCred{
+		Project: "test",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("test_access_key", "test_secret_key", ""),
+	}
+	dlq := NewMemory(Opts{})
+	q, err := NewSQS(cred, server.URL+"/123456789012/work", server.URL, Opts{MaxAttempts: 2, DeadLetter: dlq})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	if _, err = q.Send(ctx, []byte("experiment")); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	msg, err := q.Receive(ctx, 10*time.Second, time.Second)
+	if err != nil || msg == nil {
+		t.Fatal("message not received", err, "stack", stack.Trace().TrimRuntime())
+	}
+	if string(msg.Body) != "experiment" || msg.Attempts != 1 {
+		t.Fatal("unexpected message", msg, "stack", stack.Trace().TrimRuntime())
+	}
+	if err = q.Extend(ctx, msg, 10*time.Second); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err = q.Nack(ctx, msg, 0); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err = q.Ack(ctx, msg); err == nil {
+		t.Fatal("ack succeeded after the lease was released", "stack", stack.Trace().TrimRuntime())
+	}
+
+	msg, err = q.Receive(ctx, 10*time.Second, time.Second)
+	if err != nil || msg == nil || msg.Attempts != 2 {
+		t.Fatal("message not redelivered", msg, err, "stack", stack.Trace().TrimRuntime())
+	}
+	if err = q.Nack(ctx, msg, 0); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	// The third attempt exceeds the maximum and the message is moved to the dead letter queue
+	if msg, err = q.Receive(ctx, 10*time.Second, time.Second); msg != nil || err != nil {
+		t.Fatal("message delivered beyond its maximum attempts", msg, err, "stack", stack.Trace().TrimRuntime())
+	}
+	if backing.Len() != 0 || dlq.Len() != 1 {
+		t.Fatal("message not dead lettered", backing.Len(), dlq.Len(), "stack", stack.Trace().TrimRuntime())
+	}
+}
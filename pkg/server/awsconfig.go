@@ -0,0 +1,250 @@
+// Copyright 2018-2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package server // import "github.com/leaf-ai/go-service/pkg/server"
+
+// This file contains functions that allow servers deployed outside of Kubernetes to obtain
+// their configuration from the AWS SSM Parameter Store and AWS Secrets Manager.  The sources
+// are polled for version changes and the configuration is published using the same
+// K8sConfigUpdate messages that ConfigMaps generate so that servers can process configuration
+// without being aware of where it originated.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/lthibault/jitterbug"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/log"
+)
+
+const (
+	// ParamStoreNameSpace is the NameSpace used for updates originating from the SSM Parameter Store
+	ParamStoreNameSpace = "ssm"
+	// SecretsNameSpace is the NameSpace used for updates originating from AWS Secrets Manager
+	SecretsNameSpace = "secretsmanager"
+)
+
+// AWSConfigOpts contains the parameters used when polling AWS configuration sources
+//
+type AWSConfigOpts struct {
+	// Interval is the period between polls of the source, defaults to 60 seconds
+	Interval time.Duration
+	// Endpoint can be used to override the AWS service endpoint, for example when using a
+	// local stand-in
+	Endpoint string
+}
+
+func awsSession(cred *aws_gsc.AWSCred, opts AWSConfigOpts) (sess *session.Session, err kv.Error) {
+	cfg := &aws.Config{
+		Region:      aws.String(cred.Region),
+		Credentials: cred.Creds,
+		// A private client prevents the SDK from altering http.DefaultClient when
+		// applying a custom CA bundle, which other watchers may be using concurrently
+		HTTPClient: &http.Client{},
+	}
+	if len(opts.Endpoint) != 0 {
+		cfg.Endpoint = aws.String(opts.Endpoint)
+	}
+	sess, errGo := session.NewSession(cfg)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("endpoint", opts.Endpoint).With("stack", stack.Trace().TrimRuntime())
+	}
+	return sess, nil
+}
+
+// pollSource calls the load function on a jittered interval and sends the configuration it
+// loads to the updateC channel whenever the version of the configuration changes
+func pollSource(ctx context.Context, interval time.Duration, load func() (update K8sConfigUpdate, version string, err kv.Error), updateC chan<- K8sConfigUpdate, errorC chan<- kv.Error) {
+
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	lastVersion := ""
+	poll := func() {
+		update, version, err := load()
+		if err != nil {
+			select {
+			case errorC <- err:
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		if version == lastVersion {
+			return
+		}
+		select {
+		case updateC <- update:
+			lastVersion = version
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+		}
+	}
+
+	poll()
+
+	t := jitterbug.New(interval, &jitterbug.Norm{Stdev: interval / 10})
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			poll()
+		}
+	}
+}
+
+// WatchParameters will load all of the parameters stored under a path within the SSM Parameter
+// Store, and will send them as a single update to the updateC channel whenever any of them are
+// added, removed or have a new version.  Parameter names have the path removed when used as
+// keys within the update.
+//
+// This is a blocking function that will return when the ctx is Done().
+//
+func WatchParameters(ctx context.Context, cred *aws_gsc.AWSCred, path string, opts AWSConfigOpts, updateC chan<- K8sConfigUpdate, errorC chan<- kv.Error) {
+
+	sess, err := awsSession(cred, opts)
+	if err != nil {
+		select {
+		case errorC <- err.With("path", path):
+		case <-time.After(time.Second):
+		}
+		return
+	}
+	client := ssm.New(sess)
+
+	prefix := path
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	load := func() (update K8sConfigUpdate, version string, err kv.Error) {
+		update = K8sConfigUpdate{
+			NameSpace: ParamStoreNameSpace,
+			Name:      path,
+			State:     map[string]string{},
+		}
+		versions := []string{}
+
+		errGo := client.GetParametersByPathPagesWithContext(ctx, &ssm.GetParametersByPathInput{
+			Path:           aws.String(path),
+			Recursive:      aws.Bool(true),
+			WithDecryption: aws.Bool(true),
+		}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+			for _, param := range page.Parameters {
+				name := strings.TrimPrefix(aws.StringValue(param.Name), prefix)
+				update.State[name] = aws.StringValue(param.Value)
+				versions = append(versions, fmt.Sprint(name, ":", aws.Int64Value(param.Version)))
+			}
+			return true
+		})
+		if errGo != nil {
+			return update, "", kv.Wrap(errGo).With("path", path).With("stack", stack.Trace().TrimRuntime())
+		}
+		// Parameters are not guaranteed to be returned in a stable order
+		sort.Strings(versions)
+		return update, strings.Join(versions, ","), nil
+	}
+
+	pollSource(ctx, opts.Interval, load, updateC, errorC)
+}
+
+// WatchSecret will load a secret from AWS Secrets Manager that contains a JSON object, and will
+// send the members of the object as an update to the updateC channel whenever a new version
+// of the secret becomes current.  Members that are not strings are sent using their JSON
+// encoding.
+//
+// This is a blocking function that will return when the ctx is Done().
+//
+func WatchSecret(ctx context.Context, cred *aws_gsc.AWSCred, secretID string, opts AWSConfigOpts, updateC chan<- K8sConfigUpdate, errorC chan<- kv.Error) {
+
+	sess, err := awsSession(cred, opts)
+	if err != nil {
+		select {
+		case errorC <- err.With("secret", secretID):
+		case <-time.After(time.Second):
+		}
+		return
+	}
+	client := secretsmanager.New(sess)
+
+	load := func() (update K8sConfigUpdate, version string, err kv.Error) {
+		update = K8sConfigUpdate{
+			NameSpace: SecretsNameSpace,
+			Name:      secretID,
+			State:     map[string]string{},
+		}
+
+		output, errGo := client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secretID),
+		})
+		if errGo != nil {
+			return update, "", kv.Wrap(errGo).With("secret", secretID).With("stack", stack.Trace().TrimRuntime())
+		}
+
+		members := map[string]json.RawMessage{}
+		if errGo = json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &members); errGo != nil {
+			return update, "", kv.Wrap(errGo).With("secret", secretID, "version", aws.StringValue(output.VersionId)).With("stack", stack.Trace().TrimRuntime())
+		}
+		for k, raw := range members {
+			value := ""
+			if errGo := json.Unmarshal(raw, &value); errGo != nil {
+				value = string(raw)
+			}
+			update.State[k] = value
+		}
+		return update, aws.StringValue(output.VersionId), nil
+	}
+
+	pollSource(ctx, opts.Interval, load, updateC, errorC)
+}
+
+// InitiateAWSConfig is used by servers not running within Kubernetes to source their configuration
+// from a path within the SSM Parameter Store and, optionally, a secret within AWS Secrets Manager.
+// Updates are broadcast using the same ConfigListeners returned by K8sConfigUpdates().
+//
+// This is a blocking function that will return when the ctx is Done().
+//
+func InitiateAWSConfig(ctx context.Context, cred *aws_gsc.AWSCred, paramPath string, secretID string, opts AWSConfigOpts, readyC chan struct{}, logger *log.Logger, errorC chan kv.Error) {
+
+	if len(paramPath) == 0 && len(secretID) == 0 {
+		return
+	}
+
+	protect.Lock()
+	if configListeners == nil {
+		configListeners = NewConfigBroadcast(ctx, errorC)
+	}
+	listeners := configListeners
+	protect.Unlock()
+
+	closeReady(readyC)
+
+	if len(secretID) != 0 {
+		go WatchSecret(ctx, cred, secretID, opts, listeners.Master, errorC)
+	}
+	if len(paramPath) != 0 {
+		go WatchParameters(ctx, cred, paramPath, opts, listeners.Master, errorC)
+	}
+
+	if logger != nil {
+		logger.Info("AWS configuration sources started", "path", paramPath, "secret", secretID)
+	}
+
+	<-ctx.Done()
+}
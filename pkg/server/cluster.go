@@ -15,7 +15,27 @@ var (
 	configListeners *ConfigListeners
 )
 
+// closeReady closes a ready channel unless it has already been closed by another
+// configuration source.  Ready channels are only ever closed, so a receive that does not
+// block shows the channel has been closed already.
+func closeReady(readyC chan struct{}) {
+	if readyC == nil {
+		return
+	}
+
+	protect.Lock()
+	defer protect.Unlock()
+
+	select {
+	case <-readyC:
+	default:
+		close(readyC)
+	}
+}
+
 func K8sConfigUpdates() (l *ConfigListeners) {
+	protect.Lock()
+	defer protect.Unlock()
 	return configListeners
 }
 
@@ -27,12 +47,15 @@ func InitiateK8s(ctx context.Context, namespace string, cfgMap string, readyC ch
 		return
 	}
 
-	configListeners = NewConfigBroadcast(ctx, errorC)
+	// The broadcaster may already have been created by another configuration source
+	protect.Lock()
+	if configListeners == nil {
+		configListeners = NewConfigBroadcast(ctx, errorC)
+	}
+	listeners := configListeners
+	protect.Unlock()
 
-	func() {
-		defer recover()
-		close(readyC)
-	}()
+	closeReady(readyC)
 
 	// Watch for k8s API connectivity events that are of interest and use the errorC to surface them
 	go MonitorK8s(ctx, errorC)
@@ -45,7 +68,7 @@ func InitiateK8s(ctx context.Context, namespace string, cfgMap string, readyC ch
 		select {
 		case <-tick.C:
 			// If k8s is specified we need to start a listener for config maps updates:
-			if err := ListenK8sConfigMaps(ctx, namespace, configListeners.Master, errorC, logger); err != nil {
+			if err := ListenK8sConfigMaps(ctx, namespace, listeners.Master, errorC, logger); err != nil {
 				logger.Warn("k8s config maps monitoring offline", "error", err.Error())
 			}
 		case <-ctx.Done():
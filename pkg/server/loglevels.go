@@ -0,0 +1,118 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package server // import "github.com/leaf-ai/go-service/pkg/server"
+
+// This file contains the handling of log levels supplied using configuration updates, for
+// example from a ConfigMap.  The LOG_LEVEL key sets the level of every component while keys
+// such as LOG_LEVEL_RUNNER set the level of a single component.  Component names are matched
+// using log.NormalizeComponent, ignoring case and treating hyphens and periods as underscores.
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/log"
+)
+
+// LogLevelKey is the configuration key used to set log levels
+const LogLevelKey = "LOG_LEVEL"
+
+// ApplyLogLevels sets log levels using any LOG_LEVEL keys within a configuration update,
+// the level for all components is applied before the levels of individual components.
+// Every key present is applied, WatchLogLevels only applies keys whose values have changed.
+//
+func ApplyLogLevels(update K8sConfigUpdate) (err kv.Error) {
+	return applyLogLevels(update, nil)
+}
+
+// applyLogLevels sets log levels using the LOG_LEVEL keys of an update.  When applied is
+// supplied keys whose value matches the value last applied are skipped, so that levels
+// changed by other means, such as signals, are not reverted by unrelated updates.
+func applyLogLevels(update K8sConfigUpdate, applied map[string]string) (err kv.Error) {
+	changed := func(key string, text string) bool {
+		if applied == nil {
+			return true
+		}
+		last, isPresent := applied[key]
+		return !isPresent || last != text
+	}
+
+	if text, isPresent := update.State[LogLevelKey]; isPresent && changed(LogLevelKey, text) {
+		level, errLevel := log.ParseLevel(text)
+		if errLevel != nil {
+			return errLevel.With("key", LogLevelKey)
+		}
+		log.SetAllLevels(level)
+		if applied != nil {
+			applied[LogLevelKey] = text
+		}
+	}
+
+	components := map[string]string{}
+	for _, component := range log.Components() {
+		components[log.NormalizeComponent(component)] = component
+	}
+
+	for key, text := range update.State {
+		if !strings.HasPrefix(key, LogLevelKey+"_") || !changed(key, text) {
+			continue
+		}
+		level, errLevel := log.ParseLevel(text)
+		if errLevel != nil {
+			err = errLevel.With("key", key)
+			continue
+		}
+		name := log.NormalizeComponent(strings.TrimPrefix(key, LogLevelKey+"_"))
+		component, isPresent := components[name]
+		if !isPresent {
+			// Levels are stored under the normalized name, loggers for the component that
+			// are created later will use the level
+			component = name
+		}
+		log.SetComponentLevel(component, level)
+		if applied != nil {
+			applied[key] = text
+		}
+	}
+	return err
+}
+
+// WatchLogLevels applies log levels from the configuration updates of a broadcaster until
+// the context is cancelled.  Keys are only applied when their value differs from the value
+// last applied by the watcher, levels changed by other means are left alone by updates that
+// do not change the keys.
+//
+func WatchLogLevels(ctx context.Context, listeners *ConfigListeners, errorC chan<- kv.Error) (err kv.Error) {
+	if listeners == nil {
+		return kv.NewError("no configuration broadcaster").With("stack", stack.Trace().TrimRuntime())
+	}
+
+	updateC := make(chan K8sConfigUpdate, 1)
+	id, err := listeners.Add(updateC)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer listeners.Delete(id)
+
+		applied := map[string]string{}
+		for {
+			select {
+			case update := <-updateC:
+				if err := applyLogLevels(update, applied); err != nil {
+					select {
+					case errorC <- err:
+					default:
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
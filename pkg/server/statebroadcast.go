@@ -21,9 +21,13 @@ import (
 type ConfigListeners struct {
 	Master    chan K8sConfigUpdate
 	listeners map[xid.ID]chan<- K8sConfigUpdate
+	beat      func()
 	sync.Mutex
 }
 
+// beatInterval is the period at which the broadcaster calls its beat function while idle
+const beatInterval = time.Second
+
 // NewConfigBroadcast is used to instantiate a Kubernetes config maps update broadcaster
 func NewConfigBroadcast(ctx context.Context, errorC chan<- kv.Error) (l *ConfigListeners) {
 	l = &ConfigListeners{
@@ -54,11 +58,30 @@ func (l *ConfigListeners) Delete(id xid.ID) {
 	l.Unlock()
 }
 
+// SetBeat supplies a function that the broadcaster calls each time its run loop cycles, and at
+// least once every second, that can be used to drive a liveness heartbeat
+func (l *ConfigListeners) SetBeat(beat func()) {
+	l.Lock()
+	l.beat = beat
+	l.Unlock()
+}
+
 func (l *ConfigListeners) run(ctx context.Context, errorC chan<- kv.Error) {
+	tick := time.NewTicker(beatInterval)
+	defer tick.Stop()
+
 	for {
+		l.Lock()
+		beat := l.beat
+		l.Unlock()
+		if beat != nil {
+			beat()
+		}
+
 		select {
 		case <-ctx.Done():
 			return
+		case <-tick.C:
 		case state := <-l.Master:
 
 			clients := make([]chan<- K8sConfigUpdate, 0, len(l.listeners))
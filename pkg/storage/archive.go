@@ -0,0 +1,168 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package storage // import "github.com/leaf-ai/go-service/pkg/storage"
+
+// This file contains functions for uploading directories as tar archives into a storage
+// backend, and for downloading and extracting them, independently of the backend in use
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/archive"
+)
+
+// PutTar streams a tar archive of the files cataloged by the TarWriter into an item
+//
+func PutTar(ctx context.Context, store Storage, key string, files *archive.TarWriter) (err kv.Error) {
+	w, err := store.Create(ctx, key, "application/x-tar")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	if err = files.Write(tw); err != nil {
+		w.Abort()
+		return err.With("key", key)
+	}
+	if errGo := tw.Close(); errGo != nil {
+		w.Abort()
+		return kv.Wrap(errGo).With("key", key).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo := w.Close(); errGo != nil {
+		return kv.Wrap(errGo).With("key", key).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// GetTar extracts the tar archive held in an item into a directory.  Directories, regular
+// files, symbolic links and hard links are extracted, other types of entry are rejected.
+// Entries that would be written outside of the directory, or through a link extracted
+// earlier, are rejected.
+//
+func GetTar(ctx context.Context, store Storage, key string, dir string) (err kv.Error) {
+	rdr, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	dir = filepath.Clean(dir)
+	tr := tar.NewReader(rdr)
+	for {
+		header, errGo := tr.Next()
+		if errGo == io.EOF {
+			return nil
+		}
+		if errGo != nil {
+			return kv.Wrap(errGo).With("key", key).With("stack", stack.Trace().TrimRuntime())
+		}
+		if err = extract(tr, header, dir); err != nil {
+			return err.With("key", key)
+		}
+	}
+}
+
+// checkLinks rejects targets reached through a symbolic link, or that are themselves a link.
+// The text of each link is checked when it is extracted, however links can be chained so
+// that together they lead outside of the directory.
+func checkLinks(dir string, target string) (err kv.Error) {
+	rel, errGo := filepath.Rel(dir, target)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	if rel == "." {
+		return nil
+	}
+
+	path := dir
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, component)
+		info, errGo := os.Lstat(path)
+		if os.IsNotExist(errGo) {
+			// Nothing beneath a missing path can exist
+			return nil
+		}
+		if errGo != nil {
+			return kv.Wrap(errGo).With("path", path).With("stack", stack.Trace().TrimRuntime())
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return kv.NewError("archive entry through a link").With("link", path).With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	return nil
+}
+
+func extract(tr *tar.Reader, header *tar.Header, dir string) (err kv.Error) {
+	target := filepath.Join(dir, filepath.FromSlash(header.Name))
+	if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+		return kv.NewError("archive entry outside of directory").With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+	}
+	if err = checkLinks(dir, target); err != nil {
+		return err.With("name", header.Name)
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if errGo := os.MkdirAll(target, os.FileMode(header.Mode)|0700); errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+		}
+	case tar.TypeReg:
+		if errGo := os.MkdirAll(filepath.Dir(target), 0700); errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+		}
+		f, errGo := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode)&os.ModePerm)
+		if errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+		}
+		_, errGo = io.Copy(f, tr)
+		if errClose := f.Close(); errGo == nil {
+			errGo = errClose
+		}
+		if errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+		}
+	case tar.TypeSymlink:
+		link := header.Linkname
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(target), link)
+		}
+		if link != dir && !strings.HasPrefix(filepath.Clean(link), dir+string(filepath.Separator)) {
+			return kv.NewError("archive link outside of directory").With("name", header.Name, "link", header.Linkname).With("stack", stack.Trace().TrimRuntime())
+		}
+		if errGo := os.MkdirAll(filepath.Dir(target), 0700); errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+		}
+		if errGo := os.Symlink(header.Linkname, target); errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+		}
+	case tar.TypeLink:
+		// Hard links name an entry of the archive, which must already have been extracted
+		// within the directory and must not be reached through a link
+		source := filepath.Join(dir, filepath.FromSlash(header.Linkname))
+		if !strings.HasPrefix(source, dir+string(filepath.Separator)) {
+			return kv.NewError("archive link outside of directory").With("name", header.Name, "link", header.Linkname).With("stack", stack.Trace().TrimRuntime())
+		}
+		if err = checkLinks(dir, source); err != nil {
+			return err.With("name", header.Name)
+		}
+		if errGo := os.MkdirAll(filepath.Dir(target), 0700); errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name).With("stack", stack.Trace().TrimRuntime())
+		}
+		if errGo := os.Link(source, target); errGo != nil {
+			return kv.Wrap(errGo).With("name", header.Name, "link", header.Linkname).With("stack", stack.Trace().TrimRuntime())
+		}
+	case tar.TypeXGlobalHeader:
+		// Only holds metadata for the entries that follow
+	default:
+		return kv.NewError("unsupported archive entry type").With("name", header.Name, "type", string(header.Typeflag)).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
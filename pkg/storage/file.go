@@ -0,0 +1,229 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package storage // import "github.com/leaf-ai/go-service/pkg/storage"
+
+// This file contains the implementation of a storage backend using a directory within a local,
+// or network mounted, file system.  Items are written to temporary files that are renamed into
+// place when complete so that readers never observe partial contents.
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+const (
+	// partialSuffix marks files that are still being written
+	partialSuffix = ".partial"
+)
+
+// File is a storage backend that holds items as files beneath a root directory
+//
+type File struct {
+	Root string
+}
+
+// NewFile creates a storage backend rooted at a directory, creating the directory if needed
+//
+func NewFile(root string) (store *File, err kv.Error) {
+	if len(root) == 0 {
+		return nil, kv.NewError("root directory not specified").With("stack", stack.Trace().TrimRuntime())
+	}
+	root = filepath.Clean(root)
+	if errGo := os.MkdirAll(root, 0700); errGo != nil {
+		return nil, kv.Wrap(errGo).With("root", root).With("stack", stack.Trace().TrimRuntime())
+	}
+	return &File{Root: root}, nil
+}
+
+func (f *File) path(key string) (file string, err kv.Error) {
+	key, err = cleanKey(key)
+	if err != nil {
+		return "", err.With("root", f.Root)
+	}
+	return filepath.Join(f.Root, filepath.FromSlash(key)), nil
+}
+
+func (f *File) wrap(errGo error, key string) (err kv.Error) {
+	return kv.Wrap(errGo).With("root", f.Root, "key", key).With("stack", stack.Trace().TrimRuntime())
+}
+
+func (f *File) info(key string, fi os.FileInfo) (info *ObjectInfo) {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+		LastModified: fi.ModTime(),
+	}
+}
+
+// Open returns a reader for the contents of a file
+//
+func (f *File) Open(ctx context.Context, key string) (rdr io.ReadCloser, err kv.Error) {
+	file, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fh, errGo := os.Open(file)
+	if errGo != nil {
+		return nil, f.wrap(errGo, key)
+	}
+	return fh, nil
+}
+
+// fileWriter writes to a temporary file that is renamed to its final name when closed
+type fileWriter struct {
+	*os.File
+	final string
+	key   string
+	root  string
+}
+
+func (w *fileWriter) Close() (errGo error) {
+	if errGo = w.File.Close(); errGo == nil {
+		errGo = os.Rename(w.File.Name(), w.final)
+	}
+	if errGo != nil {
+		_ = os.Remove(w.File.Name())
+		return kv.Wrap(errGo).With("root", w.root, "key", w.key).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+func (w *fileWriter) Abort() {
+	_ = w.File.Close()
+	_ = os.Remove(w.File.Name())
+}
+
+// Create returns a writer for a file, the content type is not recorded by this backend
+//
+func (f *File) Create(ctx context.Context, key string, contentType string) (w Writer, err kv.Error) {
+	file, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if errGo := os.MkdirAll(filepath.Dir(file), 0700); errGo != nil {
+		return nil, f.wrap(errGo, key)
+	}
+	fh, errGo := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*"+partialSuffix)
+	if errGo != nil {
+		return nil, f.wrap(errGo, key)
+	}
+	return &fileWriter{
+		File:  fh,
+		final: file,
+		key:   key,
+		root:  f.Root,
+	}, nil
+}
+
+// List returns the details of files having keys that start with the prefix, files that are
+// still being written are excluded
+//
+func (f *File) List(ctx context.Context, prefix string) (infos []ObjectInfo, err kv.Error) {
+	infos = []ObjectInfo{}
+
+	// Only the directory that could contain the prefix needs to be walked
+	start := f.Root
+	if dir := filepath.Dir(filepath.FromSlash(prefix)); dir != "." {
+		if start, err = f.path(filepath.ToSlash(dir)); err != nil {
+			return nil, err
+		}
+	}
+
+	errGo := filepath.WalkDir(start, func(file string, d fs.DirEntry, errGo error) error {
+		if errGo != nil {
+			if os.IsNotExist(errGo) && file == start {
+				return filepath.SkipDir
+			}
+			return errGo
+		}
+		if errGo = ctx.Err(); errGo != nil {
+			return errGo
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), partialSuffix) {
+			return nil
+		}
+		rel, errGo := filepath.Rel(f.Root, file)
+		if errGo != nil {
+			return errGo
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, errGo := d.Info()
+		if errGo != nil {
+			if os.IsNotExist(errGo) {
+				return nil
+			}
+			return errGo
+		}
+		infos = append(infos, *f.info(key, fi))
+		return nil
+	})
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("root", f.Root, "prefix", prefix).With("stack", stack.Trace().TrimRuntime())
+	}
+	return infos, nil
+}
+
+// Stat returns the details of a single file
+//
+func (f *File) Stat(ctx context.Context, key string) (info *ObjectInfo, err kv.Error) {
+	file, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, errGo := os.Stat(file)
+	if errGo != nil {
+		return nil, f.wrap(errGo, key)
+	}
+	if fi.IsDir() {
+		return nil, f.wrap(fs.ErrNotExist, key)
+	}
+	return f.info(key, fi), nil
+}
+
+// Delete removes a file
+//
+func (f *File) Delete(ctx context.Context, key string) (err kv.Error) {
+	file, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if errGo := os.Remove(file); errGo != nil && !os.IsNotExist(errGo) {
+		return f.wrap(errGo, key)
+	}
+	return nil
+}
+
+// Copy duplicates a file
+//
+func (f *File) Copy(ctx context.Context, srcKey string, dstKey string) (err kv.Error) {
+	rdr, err := f.Open(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	w, err := f.Create(ctx, dstKey, "")
+	if err != nil {
+		return err
+	}
+	if _, errGo := io.Copy(w, rdr); errGo != nil {
+		w.Abort()
+		return f.wrap(errGo, srcKey).With("destination", dstKey)
+	}
+	if errGo := w.Close(); errGo != nil {
+		return f.wrap(errGo, srcKey).With("destination", dstKey)
+	}
+	return nil
+}
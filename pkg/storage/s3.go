@@ -0,0 +1,178 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package storage // import "github.com/leaf-ai/go-service/pkg/storage"
+
+// This file contains the implementation of a storage backend using an S3 compatible object
+// store, such as AWS S3, minio or GCS through its S3 interoperability API.
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/objstore"
+)
+
+// S3 is a storage backend that holds items as objects beneath a prefix within a bucket
+//
+type S3 struct {
+	Prefix string
+
+	store *objstore.Store
+}
+
+// NewS3 creates a storage backend for the objects beneath a prefix within a bucket
+//
+func NewS3(cred *aws_gsc.AWSCred, bucket string, prefix string, opts objstore.StoreOpts) (store *S3, err kv.Error) {
+	s, err := objstore.NewStore(cred, bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.Trim(prefix, "/")
+	if len(prefix) != 0 {
+		prefix += "/"
+	}
+	return &S3{
+		Prefix: prefix,
+		store:  s,
+	}, nil
+}
+
+func (s *S3) objectKey(key string) (objKey string, err kv.Error) {
+	if key, err = cleanKey(key); err != nil {
+		return "", err.With("bucket", s.store.Bucket, "prefix", s.Prefix)
+	}
+	return s.Prefix + key, nil
+}
+
+func (s *S3) info(objInfo *objstore.ObjectInfo) (info *ObjectInfo) {
+	return &ObjectInfo{
+		Key:          strings.TrimPrefix(objInfo.Key, s.Prefix),
+		Size:         objInfo.Size,
+		ContentType:  objInfo.ContentType,
+		LastModified: objInfo.LastModified,
+		Checksum:     objInfo.Checksum,
+	}
+}
+
+// Open returns a reader for the contents of an object, the checksum of the object is
+// verified as it is read
+//
+func (s *S3) Open(ctx context.Context, key string) (rdr io.ReadCloser, err kv.Error) {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	rdr, _, err = s.store.Get(ctx, objKey)
+	return rdr, err
+}
+
+// s3Writer streams the data written to it into an upload that is running in the background
+type s3Writer struct {
+	pw      *io.PipeWriter
+	resultC chan kv.Error
+	cancel  context.CancelFunc
+}
+
+func (w *s3Writer) Write(p []byte) (n int, errGo error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() (errGo error) {
+	_ = w.pw.Close()
+	err := <-w.resultC
+	w.cancel()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *s3Writer) Abort() {
+	// Cancelling before closing the pipe prevents the upload from completing
+	w.cancel()
+	_ = w.pw.CloseWithError(context.Canceled)
+	<-w.resultC
+}
+
+// Create returns a writer that uploads to an object, large objects are uploaded in parts
+// while being written
+//
+func (s *S3) Create(ctx context.Context, key string, contentType string) (w Writer, err kv.Error) {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadCtx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	writer := &s3Writer{
+		pw:      pw,
+		resultC: make(chan kv.Error, 1),
+		cancel:  cancel,
+	}
+
+	go func() {
+		_, err := s.store.Put(uploadCtx, objKey, pr, contentType, nil)
+		// Unblock the writer should the upload have stopped early
+		_ = pr.CloseWithError(io.ErrClosedPipe)
+		writer.resultC <- err
+	}()
+
+	return writer, nil
+}
+
+// List returns the details of the objects having keys that start with the prefix
+//
+func (s *S3) List(ctx context.Context, prefix string) (infos []ObjectInfo, err kv.Error) {
+	objInfos, err := s.store.List(ctx, s.Prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	infos = make([]ObjectInfo, 0, len(objInfos))
+	for i := range objInfos {
+		infos = append(infos, *s.info(&objInfos[i]))
+	}
+	return infos, nil
+}
+
+// Stat returns the details of a single object
+//
+func (s *S3) Stat(ctx context.Context, key string) (info *ObjectInfo, err kv.Error) {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	objInfo, err := s.store.Stat(ctx, objKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.info(objInfo), nil
+}
+
+// Delete removes an object
+//
+func (s *S3) Delete(ctx context.Context, key string) (err kv.Error) {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return err
+	}
+	return s.store.Delete(ctx, objKey)
+}
+
+// Copy duplicates an object within the bucket
+//
+func (s *S3) Copy(ctx context.Context, srcKey string, dstKey string) (err kv.Error) {
+	srcObjKey, err := s.objectKey(srcKey)
+	if err != nil {
+		return err
+	}
+	dstObjKey, err := s.objectKey(dstKey)
+	if err != nil {
+		return err
+	}
+	return s.store.Copy(ctx, srcObjKey, dstObjKey)
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package storage // import "github.com/leaf-ai/go-service/pkg/storage"
+
+// This file contains the definition of a storage interface that hides the differences between
+// local, or network mounted, file systems and S3 compatible object stores.  Code that moves
+// data such as archives can be written against the interface and the backend selected at run
+// time using a URL.
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/objstore"
+)
+
+// ObjectInfo describes an item held within a storage backend
+//
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	Checksum     string // The hex encoded SHA256 of the contents, if known to the backend
+}
+
+// Writer is used to create the contents of an item, the item only becomes visible once
+// Close has returned successfully.  Abort discards anything written.
+//
+type Writer interface {
+	io.Writer
+	Close() (errGo error)
+	Abort()
+}
+
+// Storage is implemented by backends able to hold items identified by slash separated
+// keys.  Keys are relative to the root of the backend, as specified by its URL.
+//
+type Storage interface {
+	// Open returns a reader for the contents of an item
+	Open(ctx context.Context, key string) (rdr io.ReadCloser, err kv.Error)
+	// Create returns a writer that replaces the contents of an item once it is closed
+	Create(ctx context.Context, key string, contentType string) (w Writer, err kv.Error)
+	// List returns the details of the items having keys that start with the prefix
+	List(ctx context.Context, prefix string) (infos []ObjectInfo, err kv.Error)
+	// Stat returns the details of a single item
+	Stat(ctx context.Context, key string) (info *ObjectInfo, err kv.Error)
+	// Delete removes an item, deleting an item that does not exist is not an error
+	Delete(ctx context.Context, key string) (err kv.Error)
+	// Copy duplicates an item within the backend
+	Copy(ctx context.Context, srcKey string, dstKey string) (err kv.Error)
+}
+
+// Opts contains the parameters used by backends that need more than their URL to be
+// accessed
+//
+type Opts struct {
+	// Cred supplies credentials for S3 compatible backends
+	Cred *aws_gsc.AWSCred
+	// Store contains options for S3 compatible backends, the endpoint can also be supplied
+	// using an endpoint query parameter on the URL in which case path style addressing is used
+	Store objstore.StoreOpts
+}
+
+// New selects and creates a storage backend using the scheme of a URL, for example
+// file:///var/lib/runner/artifacts or s3://bucket/prefix
+//
+func New(rawURL string, opts Opts) (store Storage, err kv.Error) {
+	u, errGo := url.Parse(rawURL)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("url", rawURL).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "file", "":
+		if len(u.Host) != 0 && u.Host != "localhost" {
+			return nil, kv.NewError("remote file URLs are not supported").With("url", rawURL).With("stack", stack.Trace().TrimRuntime())
+		}
+		return NewFile(u.Path)
+	case "s3":
+		if opts.Cred == nil {
+			return nil, kv.NewError("credentials not supplied").With("url", rawURL).With("stack", stack.Trace().TrimRuntime())
+		}
+		storeOpts := opts.Store
+		if endpoint := u.Query().Get("endpoint"); len(endpoint) != 0 {
+			storeOpts.Endpoint = endpoint
+			storeOpts.PathStyle = true
+		}
+		return NewS3(opts.Cred, u.Host, u.Path, storeOpts)
+	}
+	return nil, kv.NewError("unsupported storage scheme").With("url", rawURL).With("stack", stack.Trace().TrimRuntime())
+}
+
+// IsNotFound can be used to test errors returned by any of the backends to see if they were
+// caused by a missing item
+//
+func IsNotFound(err kv.Error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, fs.ErrNotExist) || objstore.IsNotFound(err)
+}
+
+// cleanKey validates a key and converts it into a canonical form that cannot escape the
+// root of a backend
+func cleanKey(key string) (clean string, err kv.Error) {
+	clean = path.Clean("/" + key)[1:]
+	if len(clean) == 0 || clean != strings.TrimPrefix(key, "/") {
+		return "", kv.NewError("invalid key").With("key", key).With("stack", stack.Trace().TrimRuntime())
+	}
+	return clean, nil
+}
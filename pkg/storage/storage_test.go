@@ -0,0 +1,371 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-stack/stack"
+
+	"github.com/leaf-ai/go-service/pkg/archive"
+	"github.com/leaf-ai/go-service/pkg/aws_gsc"
+	"github.com/leaf-ai/go-service/pkg/objstore/fakes3"
+)
+
+func put(ctx context.Context, t *testing.T, store Storage, key string, content string) {
+	w, err := store.Create(ctx, key, "text/plain")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if _, errGo := w.Write([]byte(content)); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if errGo := w.Close(); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+func get(ctx context.Context, t *testing.T, store Storage, key string) (content string) {
+	rdr, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer rdr.Close()
+	data, errGo := ioutil.ReadAll(rdr)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	return string(data)
+}
+
+// exercise runs the operations common to all backends
+func exercise(ctx context.Context, t *testing.T, store Storage) {
+	put(ctx, t, store, "runs/1/output.txt", "first run")
+	put(ctx, t, store, "runs/2/output.txt", "second run")
+	put(ctx, t, store, "other.txt", "other")
+
+	if content := get(ctx, t, store, "runs/1/output.txt"); content != "first run" {
+		t.Fatal("unexpected content", content, "stack", stack.Trace().TrimRuntime())
+	}
+
+	info, err := store.Stat(ctx, "runs/2/output.txt")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if info.Key != "runs/2/output.txt" || info.Size != int64(len("second run")) {
+		t.Fatal("unexpected info", info, "stack", stack.Trace().TrimRuntime())
+	}
+
+	infos, err := store.List(ctx, "runs/")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	keys := []string{}
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "runs/1/output.txt,runs/2/output.txt" {
+		t.Fatal("unexpected listing", keys, "stack", stack.Trace().TrimRuntime())
+	}
+
+	if err = store.Copy(ctx, "runs/1/output.txt", "copies/1.txt"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if content := get(ctx, t, store, "copies/1.txt"); content != "first run" {
+		t.Fatal("unexpected copy", content, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// An aborted writer leaves the existing contents untouched
+	w, err := store.Create(ctx, "other.txt", "text/plain")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	_, _ = w.Write([]byte("partial"))
+	w.Abort()
+	if content := get(ctx, t, store, "other.txt"); content != "other" {
+		t.Fatal("aborted write was visible", content, "stack", stack.Trace().TrimRuntime())
+	}
+
+	if err = store.Delete(ctx, "other.txt"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if _, err = store.Stat(ctx, "other.txt"); !IsNotFound(err) {
+		t.Fatal("deleted item still present", err, "stack", stack.Trace().TrimRuntime())
+	}
+	if err = store.Delete(ctx, "other.txt"); err != nil {
+		t.Fatal("deleting a missing item failed", err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	if _, err = store.Open(ctx, "../escape.txt"); err == nil {
+		t.Fatal("key escaping the root was accepted", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestFile exercises the filesystem backend including archive upload and download
+//
+func TestFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	root, errGo := ioutil.TempDir("", "storage-test")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(root)
+
+	store, err := New("file://"+filepath.ToSlash(filepath.Join(root, "store")), Opts{})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if _, isFile := store.(*File); !isFile {
+		t.Fatal("unexpected backend", store, "stack", stack.Trace().TrimRuntime())
+	}
+
+	exercise(ctx, t, store)
+
+	// Archive a directory into the store and extract it elsewhere
+	src := filepath.Join(root, "src")
+	if errGo = os.MkdirAll(filepath.Join(src, "nested"), 0700); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	files := map[string]string{
+		"metadata.json":      `{"experiment": 1}`,
+		"nested/output.log":  "log contents",
+		"nested/results.csv": "a,b\n1,2\n",
+	}
+	for name, content := range files {
+		if errGo = ioutil.WriteFile(filepath.Join(src, filepath.FromSlash(name)), []byte(content), 0600); errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	tw, err := archive.NewTarWriter(src)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err = PutTar(ctx, store, "archives/run.tar", tw); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	dst := filepath.Join(root, "dst")
+	if err = GetTar(ctx, store, "archives/run.tar", dst); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	for name, content := range files {
+		data, errGo := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		if string(data) != content {
+			t.Fatal("unexpected extracted contents", name, string(data), "stack", stack.Trace().TrimRuntime())
+		}
+	}
+
+	// Temporary files from writes in progress are never listed
+	w, err := store.Create(ctx, "archives/pending.tar", "application/x-tar")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer w.Abort()
+	infos, err := store.List(ctx, "archives/")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if len(infos) != 1 || infos[0].Key != "archives/run.tar" {
+		t.Fatal("unexpected listing", infos, "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// tarOf returns a tar archive of the entries, regular files contain their own name
+func tarOf(t *testing.T, headers []*tar.Header) (archive string) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if errGo := tw.WriteHeader(header); errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, errGo := tw.Write([]byte(header.Name)); errGo != nil {
+				t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+			}
+		}
+	}
+	if errGo := tw.Close(); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	return buf.String()
+}
+
+// TestTarLinks checks that a chain of links, each of which stays within the directory, cannot
+// be used to write outside of it
+//
+func TestTarLinks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	root, errGo := ioutil.TempDir("", "storage-links")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(root)
+
+	store, err := New("file://"+filepath.ToSlash(filepath.Join(root, "store")), Opts{})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	put(ctx, t, store, "archives/links.tar", tarOf(t, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: ".", Mode: 0777},
+		{Name: "a/x", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+		{Name: "a/x/escaped.txt", Typeflag: tar.TypeReg, Mode: 0600},
+	}))
+
+	dst := filepath.Join(root, "dst")
+	if err = GetTar(ctx, store, "archives/links.tar", dst); err == nil {
+		t.Fatal("chained links were followed", "stack", stack.Trace().TrimRuntime())
+	}
+	for _, escaped := range []string{filepath.Join(root, "escaped.txt"), filepath.Join(dst, "escaped.txt")} {
+		if _, errGo = os.Lstat(escaped); !os.IsNotExist(errGo) {
+			t.Fatal("file written through links", escaped, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestTarEntryTypes checks that hard links are extracted when they stay within the directory,
+// and that entry types which cannot be extracted are rejected rather than skipped
+//
+func TestTarEntryTypes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	root, errGo := ioutil.TempDir("", "storage-types")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(root)
+
+	store, err := New("file://"+filepath.ToSlash(filepath.Join(root, "store")), Opts{})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	put(ctx, t, store, "archives/hard.tar", tarOf(t, []*tar.Header{
+		{Name: "data/results.csv", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "copy/results.csv", Typeflag: tar.TypeLink, Linkname: "data/results.csv"},
+	}))
+	dst := filepath.Join(root, "hard")
+	if err = GetTar(ctx, store, "archives/hard.tar", dst); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if data, errGo := ioutil.ReadFile(filepath.Join(dst, "copy", "results.csv")); errGo != nil || string(data) != "data/results.csv" {
+		t.Fatal("hard link not extracted", string(data), errGo, "stack", stack.Trace().TrimRuntime())
+	}
+
+	secret := filepath.Join(root, "secret.txt")
+	if errGo = ioutil.WriteFile(secret, []byte("secret"), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	for name, headers := range map[string][]*tar.Header{
+		"outside": {
+			{Name: "stolen.txt", Typeflag: tar.TypeLink, Linkname: "../secret.txt"},
+		},
+		"through-symlink": {
+			{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "data", Mode: 0777},
+			{Name: "data/x", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "stolen.txt", Typeflag: tar.TypeLink, Linkname: "up/x"},
+		},
+		"fifo": {
+			{Name: "pipe", Typeflag: tar.TypeFifo, Mode: 0600},
+		},
+	} {
+		key := "archives/" + name + ".tar"
+		put(ctx, t, store, key, tarOf(t, headers))
+		dst := filepath.Join(root, name)
+		if err = GetTar(ctx, store, key, dst); err == nil {
+			t.Fatal("archive entry accepted", name, "stack", stack.Trace().TrimRuntime())
+		}
+		if _, errGo = os.Lstat(filepath.Join(dst, "stolen.txt")); !os.IsNotExist(errGo) {
+			t.Fatal("link extracted", name, "stack", stack.Trace().TrimRuntime())
+		}
+	}
+}
+
+// TestS3 exercises the S3 compatible backend using a fake S3 server
+//
+func TestS3(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	server := fakes3.New()
+	defer server.Close()
+	server.CreateBucket("test-bucket")
+
+	cred := &aws_gsc.AWSCred{
+		Project: "test",
+		Region:  "us-west-2",
+		Creds:   credentials.NewStaticCredentials("test_access_key", "test_secret_key", ""),
+	}
+
+	if _, err := New("s3://test-bucket/experiments", Opts{}); err == nil {
+		t.Fatal("s3 backend created without credentials", "stack", stack.Trace().TrimRuntime())
+	}
+
+	store, err := New("s3://test-bucket/experiments?endpoint="+url.QueryEscape(server.URL), Opts{Cred: cred})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if backend, isS3 := store.(*S3); !isS3 || backend.Prefix != "experiments/" {
+		t.Fatal("unexpected backend", store, "stack", stack.Trace().TrimRuntime())
+	}
+
+	exercise(ctx, t, store)
+
+	// Items must be held beneath the prefix of the URL
+	direct, err := New("s3://test-bucket?endpoint="+url.QueryEscape(server.URL), Opts{Cred: cred})
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if content := get(ctx, t, direct, "experiments/runs/1/output.txt"); content != "first run" {
+		t.Fatal("unexpected content", content, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Archives are given the content type the file backend reports for them
+	src, errGo := ioutil.TempDir("", "storage-s3")
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(src)
+	if errGo = ioutil.WriteFile(filepath.Join(src, "output.log"), []byte("log contents"), 0600); errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	tw, err := archive.NewTarWriter(src)
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if err = PutTar(ctx, store, "archives/run.tar", tw); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	info, err := store.Stat(ctx, "archives/run.tar")
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if info.ContentType != mime.TypeByExtension(".tar") {
+		t.Fatal("unexpected content type", info.ContentType, "stack", stack.Trace().TrimRuntime())
+	}
+}
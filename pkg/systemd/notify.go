@@ -0,0 +1,235 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package systemd // import "github.com/leaf-ai/go-service/pkg/systemd"
+
+// This file contains an implementation of the systemd service notification protocol, sd_notify,
+// that is driven by the modules tracked by a components.Components.  When a server is run as a
+// systemd unit of Type=notify it is considered started once READY=1 has been sent, and when the
+// unit has WatchdogSec set it will be restarted if WATCHDOG=1 is not sent often enough.
+//
+// When the process was not started by systemd the NOTIFY_SOCKET environment variable is not set
+// and notifications are silently discarded, allowing the same code to run anywhere.
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leaf-ai/go-service/pkg/components"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// Notifier sends state notifications to the systemd service manager
+//
+type Notifier struct {
+	socket   string        // The NOTIFY_SOCKET address, empty when not run by systemd
+	watchdog time.Duration // The WATCHDOG_USEC interval, zero when the watchdog is disabled
+
+	ready    bool
+	stopping bool
+	status   string
+	sync.Mutex
+}
+
+// NewNotifier creates a notifier using the NOTIFY_SOCKET, WATCHDOG_USEC and WATCHDOG_PID
+// environment variables set by systemd
+//
+func NewNotifier() (n *Notifier, err kv.Error) {
+	n = &Notifier{
+		socket: os.Getenv("NOTIFY_SOCKET"),
+	}
+
+	usec := os.Getenv("WATCHDOG_USEC")
+	if len(usec) == 0 {
+		return n, nil
+	}
+	// The watchdog can be intended for a different process, such as a parent shell
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) != 0 && pid != strconv.Itoa(os.Getpid()) {
+		return n, nil
+	}
+	interval, errGo := strconv.ParseUint(usec, 10, 63)
+	if errGo != nil || interval == 0 {
+		return nil, kv.NewError("invalid WATCHDOG_USEC").With("value", usec).With("stack", stack.Trace().TrimRuntime())
+	}
+	n.watchdog = time.Duration(interval) * time.Microsecond
+	return n, nil
+}
+
+// Enabled tests if the process was started by systemd and notifications will be delivered
+//
+func (n *Notifier) Enabled() (enabled bool) {
+	return len(n.socket) != 0
+}
+
+// WatchdogInterval returns the interval within which systemd expects watchdog pings, zero is
+// returned when the watchdog is not enabled for this process
+//
+func (n *Notifier) WatchdogInterval() (interval time.Duration) {
+	return n.watchdog
+}
+
+// Notify sends a notification made up of newline separated VARIABLE=value assignments
+//
+func (n *Notifier) Notify(state string) (err kv.Error) {
+	if !n.Enabled() {
+		return nil
+	}
+
+	conn, errGo := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if errGo != nil {
+		return kv.Wrap(errGo).With("socket", n.socket).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer conn.Close()
+
+	if _, errGo = conn.Write([]byte(state)); errGo != nil {
+		return kv.Wrap(errGo).With("socket", n.socket).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// Ready informs systemd that the service has finished starting, along with a status line
+//
+func (n *Notifier) Ready(status string) (err kv.Error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if err = n.Notify("READY=1\nSTATUS=" + status); err != nil {
+		return err
+	}
+	n.ready = true
+	n.status = status
+	return nil
+}
+
+// Status sends a single line describing the state of the service, repeated lines are not sent
+//
+func (n *Notifier) Status(status string) (err kv.Error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if status == n.status {
+		return nil
+	}
+	if err = n.Notify("STATUS=" + status); err != nil {
+		return err
+	}
+	n.status = status
+	return nil
+}
+
+// Stopping informs systemd that the service is draining its work prior to stopping
+//
+func (n *Notifier) Stopping() (err kv.Error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.stopping {
+		return nil
+	}
+	if err = n.Notify("STOPPING=1\nSTATUS=draining"); err != nil {
+		return err
+	}
+	n.stopping = true
+	n.status = "draining"
+	return nil
+}
+
+// Watchdog sends a keep alive ping to the systemd watchdog
+//
+func (n *Notifier) Watchdog() (err kv.Error) {
+	return n.Notify("WATCHDOG=1")
+}
+
+// describe returns a status line summarizing the modules that are down
+func describe(snap *components.Snapshot) (status string) {
+	down := snap.Down()
+	if len(down) == 0 {
+		return "all modules up"
+	}
+	parts := make([]string, 0, len(down))
+	for _, name := range down {
+		part := name
+		if reason := snap.Modules[name].Reason; len(reason) != 0 {
+			part += " (" + reason + ")"
+		}
+		parts = append(parts, part)
+	}
+	return "down: " + strings.Join(parts, ", ")
+}
+
+// Run drives notifications from the state of the modules until the context is cancelled.
+// READY=1 is sent once the modules are first all up, STATUS= lines are sent as the down modules
+// change, and when the watchdog is enabled WATCHDOG=1 is sent at half its interval but only
+// while the modules are all up, so that a server that stays down is restarted.  Closing the
+// drain channel sends STOPPING=1, a nil channel can be supplied if draining is not used.
+//
+// Errors sending notifications are sent to errorC without blocking.
+//
+func (n *Notifier) Run(ctx context.Context, comps *components.Components, drainC <-chan struct{}, errorC chan<- kv.Error) {
+	if !n.Enabled() {
+		return
+	}
+
+	report := func(err kv.Error) {
+		if err == nil || errorC == nil {
+			return
+		}
+		select {
+		case errorC <- err:
+		default:
+		}
+	}
+
+	listener := make(chan bool, 1)
+	comps.AddListener(listener)
+	defer comps.RemoveListener(listener)
+
+	var pingC <-chan time.Time
+	if n.watchdog != 0 {
+		ticker := time.NewTicker(n.watchdog / 2)
+		defer ticker.Stop()
+		pingC = ticker.C
+	}
+
+	update := func() (up bool) {
+		snap := comps.Snapshot()
+		status := describe(snap)
+
+		n.Lock()
+		ready := n.ready
+		stopping := n.stopping
+		n.Unlock()
+
+		switch {
+		case stopping:
+		case !ready && snap.Up:
+			report(n.Ready(status))
+		default:
+			report(n.Status(status))
+		}
+		return snap.Up
+	}
+
+	update()
+	for {
+		select {
+		case <-listener:
+			update()
+		case <-pingC:
+			if update() {
+				report(n.Watchdog())
+			}
+		case <-drainC:
+			report(n.Stopping())
+			drainC = nil
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 (c) The Go Service Components authors. All rights reserved. Issued under the Apache 2.0 License.
+
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/leaf-ai/go-service/pkg/components"
+)
+
+// listen creates a local socket standing in for the systemd notification socket
+func listen(t *testing.T) (conn *net.UnixConn) {
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "notify.sock"), Net: "unixgram"}
+	conn, errGo := net.ListenUnixgram("unixgram", addr)
+	if errGo != nil {
+		t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", addr.Name)
+	return conn
+}
+
+// receive waits for the next notification other than a watchdog ping, counting the pings seen
+func receive(t *testing.T, conn *net.UnixConn, pings *int) (state string) {
+	buf := make([]byte, 4096)
+	for {
+		if errGo := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		n, errGo := conn.Read(buf)
+		if errGo != nil {
+			t.Fatal(errGo.Error(), "stack", stack.Trace().TrimRuntime())
+		}
+		if state = string(buf[:n]); state != "WATCHDOG=1" {
+			return state
+		}
+		*pings++
+	}
+}
+
+// TestNotifier checks the notifications sent as the modules change state and when draining
+//
+func TestNotifier(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn := listen(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	n, err := NewNotifier()
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if !n.Enabled() || n.WatchdogInterval() != 100*time.Millisecond {
+		t.Fatal("environment not used", n.Enabled(), n.WatchdogInterval(), "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps := components.InitComponentTracking(ctx)
+	comps.SetStatus("queue", components.Down, "connecting", nil)
+
+	drainC := make(chan struct{})
+	go n.Run(ctx, comps, drainC, nil)
+
+	pings := 0
+	if state := receive(t, conn, &pings); state != "STATUS=down: queue (connecting)" {
+		t.Fatal("unexpected notification", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Watchdog pings are withheld while the modules are down
+	time.Sleep(300 * time.Millisecond)
+
+	comps.SetModule("queue", true)
+	if state := receive(t, conn, &pings); state != "READY=1\nSTATUS=all modules up" {
+		t.Fatal("unexpected notification", state, "stack", stack.Trace().TrimRuntime())
+	}
+	if pings != 0 {
+		t.Fatal("watchdog pinged while down", pings, "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps.SetStatus("queue", components.Down, "connection reset", nil)
+	if state := receive(t, conn, &pings); state != "STATUS=down: queue (connection reset)" {
+		t.Fatal("unexpected notification", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	comps.SetModule("queue", true)
+	if state := receive(t, conn, &pings); state != "STATUS=all modules up" {
+		t.Fatal("unexpected notification", state, "stack", stack.Trace().TrimRuntime())
+	}
+
+	// Watchdog pings are sent while the modules are up
+	time.Sleep(300 * time.Millisecond)
+
+	close(drainC)
+	if state := receive(t, conn, &pings); state != "STOPPING=1\nSTATUS=draining" {
+		t.Fatal("unexpected notification", state, "stack", stack.Trace().TrimRuntime())
+	}
+	if pings == 0 {
+		t.Fatal("watchdog not pinged while up", "stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TestNotifierDisabled checks that notifications are discarded outside of systemd
+//
+func TestNotifierDisabled(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	t.Setenv("WATCHDOG_USEC", "")
+
+	n, err := NewNotifier()
+	if err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+	if n.Enabled() || n.WatchdogInterval() != 0 {
+		t.Fatal("notifier enabled outside of systemd", "stack", stack.Trace().TrimRuntime())
+	}
+	if err = n.Ready("ok"); err != nil {
+		t.Fatal(err.Error(), "stack", stack.Trace().TrimRuntime())
+	}
+}